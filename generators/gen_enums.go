@@ -14,7 +14,7 @@ func main() {
 		os.Exit(1)
 	}
 	defer file.Close()
-	fmt.Println("package parser\n")
+	fmt.Print("package parser\n\n")
 	scanner := bufio.NewScanner(file)
 	firstMember := true
 	enumType := ""
@@ -27,7 +27,7 @@ func main() {
 		}
 		if line[0] == '-' {
 			if enumType != "" {
-				fmt.Println(")\n\n")
+				fmt.Print(")\n\n\n")
 				fmt.Println(functionStr+"\n\tdefault: return \"???\"\n\t}\n}\n\n")
 				functionStr = ""
 			}
@@ -13,6 +13,7 @@ import (
 func main() {
 
 	debugFlag := flag.Bool("debug", false, "Print debug information")
+	strictFlag := flag.Bool("strict", false, "Disallow implicit conversions between types")
 	flag.Parse()
 
 	DEBUG := *debugFlag
@@ -51,7 +52,7 @@ func main() {
 		fmt.Println()
 	}
 
-	typed_ast, err := parser.CheckTypes(ast)
+	typed_ast, err := parser.CheckTypes(ast, *strictFlag)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
@@ -5,6 +5,7 @@ type BuiltinFunc struct {
 	returnType Type
 	parameters []ParameterNode
 	generator  bool
+	fallible   bool
 }
 
 var builtins = map[string]BuiltinFunc{
@@ -12,7 +13,65 @@ var builtins = map[string]BuiltinFunc{
 		name:       "len",
 		returnType: TypeInt{},
 		parameters: []ParameterNode{
-			ParameterNode{name: "var", typ: TypeString{}},
+			ParameterNode{name: "var", typ: TypeAny{}},
+		},
+	},
+	"len_bytes": {
+		name:       "len_bytes",
+		returnType: TypeInt{},
+		parameters: []ParameterNode{
+			ParameterNode{name: "str", typ: TypeString{}},
+		},
+	},
+	"bytes": {
+		name:       "bytes",
+		returnType: TypeSlice{ElementType: TypeInt{}},
+		parameters: []ParameterNode{
+			ParameterNode{name: "str", typ: TypeString{}},
+		},
+	},
+	"int": {
+		name:       "int",
+		returnType: TypeInt{},
+		parameters: []ParameterNode{
+			ParameterNode{name: "value", typ: TypeAny{}},
+		},
+	},
+	"float": {
+		name:       "float",
+		returnType: TypeFloat{},
+		parameters: []ParameterNode{
+			ParameterNode{name: "value", typ: TypeAny{}},
+		},
+	},
+	"str": {
+		name:       "str",
+		returnType: TypeString{},
+		parameters: []ParameterNode{
+			ParameterNode{name: "value", typ: TypeAny{}},
+		},
+	},
+	"bool": {
+		name:       "bool",
+		returnType: TypeBool{},
+		parameters: []ParameterNode{
+			ParameterNode{name: "value", typ: TypeAny{}},
+		},
+	},
+	"to_int": {
+		name:       "to_int",
+		returnType: TypeInt{},
+		fallible:   true,
+		parameters: []ParameterNode{
+			ParameterNode{name: "str", typ: TypeString{}},
+		},
+	},
+	"to_float": {
+		name:       "to_float",
+		returnType: TypeFloat{},
+		fallible:   true,
+		parameters: []ParameterNode{
+			ParameterNode{name: "str", typ: TypeString{}},
 		},
 	},
 	"append": {
@@ -35,7 +94,7 @@ var builtins = map[string]BuiltinFunc{
 		name:       "has",
 		returnType: TypeBool{},
 		parameters: []ParameterNode{
-			ParameterNode{name: "haystack", typ: TypeSet{}},
+			ParameterNode{name: "haystack", typ: TypeKeyed{}},
 			ParameterNode{name: "needle", typ: TypeAny{}},
 		},
 	},
@@ -43,7 +102,7 @@ var builtins = map[string]BuiltinFunc{
 		name:       "del",
 		returnType: TypeBool{},
 		parameters: []ParameterNode{
-			ParameterNode{name: "set", typ: TypeSet{}},
+			ParameterNode{name: "container", typ: TypeKeyed{}},
 			ParameterNode{name: "value", typ: TypeAny{}},
 		},
 	},
@@ -62,6 +121,29 @@ var builtins = map[string]BuiltinFunc{
 			ParameterNode{name: "slice", typ: TypeSlice{}},
 		},
 	},
+	"keys": {
+		name:       "keys",
+		returnType: TypeSlice{ElementType: TypeUndetermined{}},
+		parameters: []ParameterNode{
+			ParameterNode{name: "map", typ: TypeMap{}},
+		},
+	},
+	"values": {
+		name:       "values",
+		returnType: TypeSlice{ElementType: TypeUndetermined{}},
+		parameters: []ParameterNode{
+			ParameterNode{name: "map", typ: TypeMap{}},
+		},
+	},
+	"get": {
+		name:       "get",
+		returnType: TypeUndetermined{},
+		parameters: []ParameterNode{
+			ParameterNode{name: "map", typ: TypeMap{}},
+			ParameterNode{name: "key", typ: TypeAny{}},
+			ParameterNode{name: "default", typ: TypeAny{}},
+		},
+	},
 	"join": {
 		name:       "join",
 		returnType: TypeString{},
@@ -82,6 +164,7 @@ var builtins = map[string]BuiltinFunc{
 		name: "read",
 		returnType: TypeGenerator{ElementType: TypeUndetermined{}},
 		generator: true,
+		fallible: true,
 		parameters: []ParameterNode{
 			ParameterNode{name: "path", typ: TypeString{}},
 			ParameterNode{name: "chomp", typ: TypeBool{}, hasDefault: true, defaultValue: "true"},
@@ -91,10 +174,60 @@ var builtins = map[string]BuiltinFunc{
 	"slurp": {
 		name: "slurp",
 		returnType: TypeString{},
+		fallible: true,
 		parameters: []ParameterNode{
 			ParameterNode{name: "path", typ: TypeString{}},
 		},
 	},
+	"map": {
+		name:       "map",
+		returnType: TypeAny{},
+		parameters: []ParameterNode{
+			ParameterNode{name: "list", typ: TypeAny{}},
+			ParameterNode{name: "f", typ: TypeAny{}},
+		},
+	},
+	"filter": {
+		name:       "filter",
+		returnType: TypeAny{},
+		parameters: []ParameterNode{
+			ParameterNode{name: "list", typ: TypeAny{}},
+			ParameterNode{name: "f", typ: TypeAny{}},
+		},
+	},
+	"reduce": {
+		name:       "reduce",
+		returnType: TypeAny{},
+		parameters: []ParameterNode{
+			ParameterNode{name: "list", typ: TypeAny{}},
+			ParameterNode{name: "f", typ: TypeAny{}},
+			ParameterNode{name: "initial", typ: TypeAny{}},
+		},
+	},
+	"any": {
+		name:       "any",
+		returnType: TypeBool{},
+		parameters: []ParameterNode{
+			ParameterNode{name: "list", typ: TypeAny{}},
+			ParameterNode{name: "f", typ: TypeAny{}},
+		},
+	},
+	"all": {
+		name:       "all",
+		returnType: TypeBool{},
+		parameters: []ParameterNode{
+			ParameterNode{name: "list", typ: TypeAny{}},
+			ParameterNode{name: "f", typ: TypeAny{}},
+		},
+	},
+	"sort_by": {
+		name:       "sort_by",
+		returnType: TypeAny{},
+		parameters: []ParameterNode{
+			ParameterNode{name: "list", typ: TypeAny{}},
+			ParameterNode{name: "key", typ: TypeAny{}},
+		},
+	},
 	"match": {
 		name: "match",
 		returnType: TypeBool{},
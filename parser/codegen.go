@@ -1,7 +1,10 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
 	"strings"
 )
 
@@ -18,6 +21,7 @@ type Generator struct {
 	replacementCount    int
 	ignorePreStatements bool
 	tmpVarCount         int
+	fileNames           []string
 }
 
 
@@ -27,13 +31,35 @@ func (g *Generator) nilValue(typ Type) string {
 		return "0"
 	case TypeString:
 		return "\"\""
-	case TypeSlice:
-		return typ.String()+"{}"
+	case TypeBool:
+		return "false"
+	case TypeSlice, TypeSet, TypeMap:
+		return g.codegenType(typ)+"{}"
+	case TypeRecord:
+		return g.codegenType(typ)+"{}"
+	case TypeTuple:
+		return g.codegenType(typ)+"{}"
+	case TypeFunction, TypeEnum:
+		return "nil"
+	case TypeParam:
+		return fmt.Sprintf("*new(%s)", g.codegenType(typ))
 	default:
 		panic("TODO: Unimplemented nil value for type in fail")
 	}
 }
 
+// Returns the statements passing an error on to the caller of the closest returning scope.
+// Generators hand the error to the consumer through yield instead of returning it.
+func (g *Generator) propagateError(returnScope *Scope, errStr string) string {
+	if genType, isGenerator := returnScope.returnType.(TypeGenerator); isGenerator {
+		return fmt.Sprintf("yield(%s, %s); return", g.nilValue(genType.ElementType), errStr)
+	}
+	if returnScope.returnType == (TypeVoid{}) {
+		return fmt.Sprintf("return %s", errStr)
+	}
+	return fmt.Sprintf("return %s, %s", g.nilValue(returnScope.returnType), errStr)
+}
+
 func (g *Generator) getReplacementVarName(fnName string) string {
 	g.replacementCount++
 	return fmt.Sprintf("___%s_result_%d", fnName, g.replacementCount)
@@ -75,6 +101,11 @@ func (g *Generator) indent(str string) string {
 	return indentation + str
 }
 
+// Returns the source location of a node as a quoted string, used in runtime error messages
+func (g *Generator) location(token Token) string {
+	return strconv.Quote(fmt.Sprintf("%s:%d", g.fileNames[token.file], token.line+1))
+}
+
 func (g *Generator) codegenError(text string, node Node) {
 	g.errors = append(g.errors, fmt.Sprintf("%s:%d:%d: %s", "filename", node.Token().line+1, node.Token().column, text)) // FIXME: Get filename into here somehow and make line and cols work
 }
@@ -84,6 +115,16 @@ func (g *Generator) coerce(content string, from Type, to Type, mode CoercionMode
 		return content
 	}
 
+	// Values of generic types can only be formatted, eg. when interpolated into a string
+	if _, isTypeParam := from.(TypeParam); isTypeParam {
+		if to == (TypeString{}) {
+			g.addImport("fmt")
+			return fmt.Sprintf("fmt.Sprint(%s)", content)
+		}
+		g.codegenError(fmt.Sprintf("Cannot convert value of generic type %s to %s", from, to), node)
+		return content
+	}
+
 	switch from.(type) {
 	case TypeInt:
 		switch to.(type) {
@@ -137,19 +178,73 @@ func (g *Generator) coerce(content string, from Type, to Type, mode CoercionMode
 			panic("Unimplemented coercion")
 		}
 	case TypeSlice:
-		switch to.(type) {
+		switch t := to.(type) {
 		case TypeBool:
 			return fmt.Sprintf("len(%s) > 0", content)
 		case TypeInt:
 			return fmt.Sprintf("len(%s) > 0", content) // This was added to for example `if str.find("something") {` work. Does it cause any unwanted side effects?
+		case TypeSlice:
+			g.addPreludeFunction("convertSlice")
+			return fmt.Sprintf("___convertSlice(%s, %s)", content, g.converter(from.(TypeSlice).ElementType, t.ElementType, node))
 		default:
 			panic("Unimplemented coercion for slice")
 		}
+	case TypeBool:
+		switch to.(type) {
+		case TypeString:
+			g.addImport("strconv")
+			return fmt.Sprintf("strconv.FormatBool(%s)", content)
+		default:
+			g.codegenError(fmt.Sprintf("%s cannot be converted to %s", from, to), node)
+			return ""
+		}
+	case TypeSet, TypeMap:
+		switch t := to.(type) {
+		case TypeBool:
+			return fmt.Sprintf("len(%s) > 0", content)
+		case TypeSet:
+			if fromSet, isSet := from.(TypeSet); isSet {
+				g.addPreludeFunction("convertSet")
+				return fmt.Sprintf("___convertSet(%s, %s)", content, g.converter(fromSet.ElementType, t.ElementType, node))
+			}
+			g.codegenError(fmt.Sprintf("%s cannot be converted to %s", from, to), node)
+			return ""
+		case TypeMap:
+			if fromMap, isMap := from.(TypeMap); isMap {
+				g.addPreludeFunction("convertMap")
+				return fmt.Sprintf("___convertMap(%s, %s, %s)",
+					content,
+					g.converter(fromMap.KeyType, t.KeyType, node),
+					g.converter(fromMap.ValueType, t.ValueType, node),
+				)
+			}
+			g.codegenError(fmt.Sprintf("%s cannot be converted to %s", from, to), node)
+			return ""
+		default:
+			g.codegenError(fmt.Sprintf("%s cannot be converted to %s", from, to), node)
+			return ""
+		}
+	case TypeRecord, TypeTuple:
+		g.codegenError(fmt.Sprintf("%s cannot be converted to %s", from, to), node)
+		return ""
+	case TypeEnum:
+		// Every variant has a String method
+		if to == (TypeString{}) {
+			g.addImport("fmt")
+			return fmt.Sprintf("fmt.Sprint(%s)", content)
+		}
+		g.codegenError(fmt.Sprintf("%s cannot be converted to %s", from, to), node)
+		return ""
 	default:
 		panic("Unimplemented coercion")
 	}
 }
 
+// Returns a function literal converting a single element of a container, used to coerce nested types
+func (g *Generator) converter(from Type, to Type, node Node) string {
+	return fmt.Sprintf("func(v %s) %s { return %s }", g.codegenType(from), g.codegenType(to), g.coerce("v", from, to, CoercionModeDefault, node))
+}
+
 func (g *Generator) codegenNum(node *NumNode, coercion Type) string {
 	return g.coerce(node.token.str, node.NumType(), coercion, CoercionModeNumLiteral, node)
 }
@@ -160,7 +255,29 @@ func (g *Generator) codegenBool(node *BoolNode) string {
 }
 
 func (g *Generator) codegenStringLiteral(node *StringLiteralNode, coercion Type) string {
-	return g.coerce("\""+node.token.str+"\"", TypeString{}, coercion, CoercionModeDefault, node)
+	return g.coerce(strconv.Quote(node.token.str), TypeString{}, coercion, CoercionModeDefault, node)
+}
+
+func (g *Generator) codegenInterpolatedString(node *InterpolatedStringNode, coercion Type) string {
+	var parts []string
+	for i, part := range node.parts {
+		if literal, isLiteral := part.(*StringLiteralNode); isLiteral && literal.token.str == "" {
+			continue
+		}
+
+		// Expressions are generated as their own type and converted to a string afterwards,
+		// so that eg. `{a + b}` adds numbers rather than concatenating them
+		typ := node.types[i]
+		value := g.codegenExpr(part, typ)
+		if binOp, isBinOp := part.(*BinOpNode); isBinOp {
+			value = "(" + value + ")"
+			if binOp.isComparison() {
+				typ = TypeBool{}
+			}
+		}
+		parts = append(parts, g.coerce(value, typ, TypeString{}, CoercionModeDefault, part))
+	}
+	return g.coerce("("+strings.Join(parts, " + ")+")", TypeString{}, coercion, CoercionModeDefault, node)
 }
 
 func literalToStr(value string, typ Type) string {
@@ -168,7 +285,7 @@ func literalToStr(value string, typ Type) string {
 	case TypeInt, TypeFloat, TypeBool:
 		return value
 	case TypeString:
-		return fmt.Sprintf("\"%s\"", value)
+		return strconv.Quote(value)
 	default:
 		panic("Type not supported")
 	}
@@ -182,77 +299,306 @@ func (g *Generator) codegenVar(node *VarNode, coercion Type) string {
 	}
 
 	symbol, _ := g.scope.lookupSymbol(varName)
-	if symbol.category != VariableSymbol {
+	switch symbol.category {
+	case VariableSymbol:
+		return g.coerce(varName, symbol.typ, coercion, CoercionModeDefault, node)
+	case ConstantSymbol:
+		// Constants are literals, and are coerced the same way
+		return g.coerce(varName, symbol.typ, coercion, CoercionModeNumLiteral, node)
+	case FunctionSymbol:
+		return varName
+	default:
 		panic("Should be variable...") // TODO: ASSERT
 	}
-
-	return g.coerce(varName, symbol.typ, coercion, CoercionModeDefault, node)
 }
 
-func (g *Generator) codegenIndexing(node Node) string {
-	switch n :=  node.(type) {
-	case *RangeNode:
-		return fmt.Sprintf("%s:%s",
-			g.codegenExpr(n.from, TypeInt{}),
-			g.codegenExpr(n.to, TypeInt{}),
-		)
-	default:
-		return fmt.Sprintf("%s", g.codegenExpr(node, TypeInt{}))
+// Returns the from, to, step, stepped and inclusive arguments of the range preludes
+func (g *Generator) codegenRangeArguments(node *RangeNode) string {
+	step := "1"
+	_, noStep := node.step.(*NoOpNode)
+	if !noStep {
+		step = g.codegenExpr(node.step, TypeInt{})
 	}
+	return fmt.Sprintf("%s, %s, %s, %t, %t", g.codegenExpr(node.from, TypeInt{}), g.codegenExpr(node.to, TypeInt{}), step, !noStep, node.inclusive)
 }
 
-func (g *Generator) codegenIndexedVar(node *IndexedVarNode, coercion Type) string {
-	varName := node.token.str
+// Returns the bounds checked index of an element, negative indices count from the end: `row[-1]`
+func (g *Generator) codegenIndex(node *IndexedVarNode, container string) string {
+	g.addPreludeFunction("checkIndex")
+	return fmt.Sprintf("___checkIndex(len(%s), %s, %s)", container, g.codegenExpr(node.index, TypeInt{}), g.location(node.token))
+}
 
-	indexedVar := fmt.Sprintf("%s[%s]", varName, g.codegenIndexing(node.index))
+// Returns the value indexed by node. The elements of nested containers, eg. grid[1] in
+// grid[1][2], are read into a temporary, as they are used for both bounds and element.
+func (g *Generator) codegenContainer(node *IndexedVarNode) string {
+	if node.container == nil {
+		return node.token.str
+	}
+	g.tmpVarCount++
+	tmpVar := fmt.Sprintf("___container%d", g.tmpVarCount)
+	g.addPreStatement(fmt.Sprintf("%s := %s", tmpVar, g.codegenIndexedVar(node.container, NoCoercion{})))
+	return tmpVar
+}
 
-	symbol, _ := g.scope.lookupSymbol(varName)
-	if symbol.category != VariableSymbol {
+// Returns the from, to, inclusive and location arguments of the range checking preludes.
+// Open ends default to the start and end of the indexed value.
+func (g *Generator) codegenRangeBounds(node *IndexedVarNode, container string) string {
+	rangeNode := node.index.(*RangeNode)
+	g.addPreludeFunction("checkRange")
+	from := "0"
+	if _, isOpen := rangeNode.from.(*NoOpNode); !isOpen {
+		from = g.codegenExpr(rangeNode.from, TypeInt{})
+	}
+	to := fmt.Sprintf("len(%s)", container)
+	inclusive := false
+	if _, isOpen := rangeNode.to.(*NoOpNode); !isOpen {
+		to = g.codegenExpr(rangeNode.to, TypeInt{})
+		inclusive = rangeNode.inclusive
+	} else if containerType(g.scope, node) == (TypeString{}) {
+		g.addImport("unicode/utf8")
+		to = fmt.Sprintf("utf8.RuneCountInString(%s)", container)
+	}
+	return fmt.Sprintf("%s, %s, %t, %s", from, to, inclusive, g.location(node.token))
+}
+
+func (g *Generator) codegenIndexedVar(node *IndexedVarNode, coercion Type) string {
+	symbol, _ := g.scope.lookupSymbol(node.token.str)
+	if symbol.category != VariableSymbol && symbol.category != ConstantSymbol {
 		panic("Should be variable...") // TODO: ASSERT
 	}
+	varName := g.codegenContainer(node)
+	indexedType := containerType(g.scope, node)
+
+	// Reading a missing key is a runtime error, use get() to provide a default value
+	if t, isMap := indexedType.(TypeMap); isMap {
+		g.addPreludeFunction("mapGet")
+		value := fmt.Sprintf("___mapGet(%s, %s)", varName, g.codegenExpr(node.index, t.KeyType))
+		return g.coerce(value, t.ValueType, coercion, CoercionModeDefault, node)
+	}
 
-	switch t := symbol.typ.(type) {
+	_, isRange := node.index.(*RangeNode)
+	switch t := indexedType.(type) {
 	case TypeSlice:
-		return g.coerce(indexedVar, t.ElementType, coercion, CoercionModeDefault, node)
+		if isRange {
+			g.addPreludeFunction("sliceRange")
+			return g.coerce(fmt.Sprintf("___sliceRange(%s, %s)", varName, g.codegenRangeBounds(node, varName)), t, coercion, CoercionModeDefault, node)
+		}
+		return g.coerce(fmt.Sprintf("%s[%s]", varName, g.codegenIndex(node, varName)), t.ElementType, coercion, CoercionModeDefault, node)
 	case TypeString:
-		return fmt.Sprintf("string(%s)", g.coerce(indexedVar, TypeString{}, coercion, CoercionModeDefault, node))
+		if isRange {
+			g.addPreludeFunction("stringRange")
+			return g.coerce(fmt.Sprintf("___stringRange(%s, %s)", varName, g.codegenRangeBounds(node, varName)), TypeString{}, coercion, CoercionModeDefault, node)
+		}
+		g.addPreludeFunction("checkIndex")
+		g.addPreludeFunction("stringIndex")
+		return g.coerce(fmt.Sprintf("___stringIndex(%s, %s, %s)", varName, g.codegenExpr(node.index, TypeInt{}), g.location(node.token)), TypeString{}, coercion, CoercionModeDefault, node)
 	default:
 		panic("Non-indxable type")
 	}
 }
 
+func (g *Generator) codegenFieldAccess(node *FieldAccessNode, coercion Type) string {
+	field := fmt.Sprintf("%s.%s", g.codegenExpr(node.expr, NoCoercion{}), node.token.str)
+	return g.coerce(field, node.typ, coercion, CoercionModeDefault, node)
+}
+
+// Literals are built directly as the type they are coerced to, so that nested literals
+// like `[[1, 2], [3.5]]` get the element type of the outer literal
 func (g *Generator) codegenSliceLiteral(node *SliceLiteralNode, coercion Type) string {
+	sliceType := TypeSlice{ElementType: node.elementType}
+	if coercedType, isSlice := coercion.(TypeSlice); isSlice {
+		sliceType = coercedType
+	}
 	elements := []string{}
 	for _, elem := range node.elements {
-		elements = append(elements, g.codegenExpr(elem, node.elementType))
+		elements = append(elements, g.codegenExpr(elem, sliceType.ElementType))
 	}
-	return fmt.Sprintf("[]%s{%s}", g.codegenType(node.elementType), strings.Join(elements, ","))
+	slice := fmt.Sprintf("%s{%s}", g.codegenType(sliceType), strings.Join(elements, ","))
+	return g.coerce(slice, sliceType, coercion, CoercionModeDefault, node)
 }
 
 func (g *Generator) codegenSetLiteral(node *SetLiteralNode, coercion Type) string {
+	setType := TypeSet{ElementType: node.elementType}
+	if coercedType, isSet := coercion.(TypeSet); isSet {
+		setType = coercedType
+	}
 	elements := []string{}
 	for _, elem := range node.elements {
 		// TODO: If element is a literal, check that it's not a duplicate, or the go compiler will give error
-		elements = append(elements, fmt.Sprintf("%s: {}", g.codegenExpr(elem, node.elementType)))
+		elements = append(elements, fmt.Sprintf("%s: {}", g.codegenExpr(elem, setType.ElementType)))
 	}
-	return fmt.Sprintf("map[%s]struct{}{%s}", g.codegenType(node.elementType), strings.Join(elements, ","))
+	set := fmt.Sprintf("%s{%s}", g.codegenType(setType), strings.Join(elements, ","))
+	return g.coerce(set, setType, coercion, CoercionModeDefault, node)
 }
 
-func (g *Generator) codegenUnaryOp(node *UnaryOpNode) string {
+func (g *Generator) codegenMapLiteral(node *MapLiteralNode, coercion Type) string {
+	mapType := TypeMap{KeyType: node.keyType, ValueType: node.valueType}
+	if coercedType, isMap := coercion.(TypeMap); isMap {
+		mapType = coercedType
+	}
+	elements := []string{}
+	for i := range node.keys {
+		elements = append(elements, fmt.Sprintf("%s: %s",
+			g.codegenExpr(node.keys[i], mapType.KeyType),
+			g.codegenExpr(node.values[i], mapType.ValueType),
+		))
+	}
+	return g.coerce(fmt.Sprintf("%s{%s}", g.codegenType(mapType), strings.Join(elements, ",")), mapType, coercion, CoercionModeDefault, node)
+}
+
+func (g *Generator) codegenUnaryOp(node *UnaryOpNode, coercion Type) string {
 	switch node.token.kind {
 	case Not:
 		return fmt.Sprintf("%s(%s)", node.token.str, g.codegenExpr(node.expr, TypeBool{}))
+	case Minus:
+		operand := g.codegenExpr(node.expr, node.typ)
+		// Go would read `--a` as a decrement
+		_, isBinOp := node.expr.(*BinOpNode)
+		_, isUnaryOp := node.expr.(*UnaryOpNode)
+		if isBinOp || isUnaryOp || strings.HasPrefix(operand, "-") {
+			operand = "(" + operand + ")"
+		}
+		return g.coerce("-"+operand, node.typ, coercion, CoercionModeDefault, node)
 	default:
 		panic("Codegen for unary op not implemeneted")
 	}
 }
 
 func (g *Generator) codegenBinOp(node *BinOpNode, coercion Type) string {
+	if node.isArithmetic() {
+		return g.codegenArithmetic(node, coercion)
+	}
+
+	// The right operand of && and || is only evaluated when needed, so its pre-statements
+	// are kept in an if, eg. `n > 100 && (if s.to_int()? > 0 { true } else { false })`
+	if node.token.kind == LogicAnd || node.token.kind == LogicOr {
+		return g.codegenLogical(node, coercion)
+	}
+
+	// Comparisons are made between operands of their common type, and produce a bool
+	if node.isComparison() {
+		left := g.codegenWithParens(node.left, node, node.operandType)
+		mark := len(g.preStatements)
+		right := g.codegenWithParens(node.right, node, node.operandType)
+		left = g.evaluateBefore(node.left, left, node.operandType, mark)
+		return g.coerce(fmt.Sprintf("%s %s %s", left, node.token.str, right), TypeBool{}, coercion, CoercionModeDefault, node)
+	}
 	left := g.codegenWithParens(node.left, node, coercion)
+	mark := len(g.preStatements)
 	right := g.codegenWithParens(node.right, node, coercion)
+	left = g.evaluateBefore(node.left, left, coercion, mark)
 	return fmt.Sprintf("%s %s %s", left, node.token.str, right)
 }
 
+func (g *Generator) codegenLogical(node *BinOpNode, coercion Type) string {
+	left := g.codegenWithParens(node.left, node, TypeBool{})
+
+	preStatements := g.preStatements
+	g.preStatements = nil
+	g.indentLevel++
+	right := g.codegenWithParens(node.right, node, TypeBool{})
+	var statements []string
+	for _, preStatement := range g.preStatements {
+		statements = append(statements, g.indent(preStatement))
+	}
+	g.indentLevel--
+	g.preStatements = preStatements
+
+	if len(statements) == 0 {
+		return g.coerce(fmt.Sprintf("%s %s %s", left, node.token.str, right), TypeBool{}, coercion, CoercionModeDefault, node)
+	}
+
+	g.tmpVarCount++
+	resultVar := fmt.Sprintf("___logic%d", g.tmpVarCount)
+	condition := resultVar
+	if node.token.kind == LogicOr {
+		condition = "!" + resultVar
+	}
+	g.indentLevel++
+	statements = append(statements, g.indent(fmt.Sprintf("%s = %s", resultVar, right)))
+	g.indentLevel--
+	g.addPreStatement(fmt.Sprintf("%s := %s", resultVar, left))
+	g.addPreStatement(fmt.Sprintf("if %s {\n%s\n%s", condition, strings.Join(statements, "\n"), g.indent("}")))
+	return g.coerce(resultVar, TypeBool{}, coercion, CoercionModeDefault, node)
+}
+
+// Expressions are evaluated left to right. When the expressions generated after one add
+// pre-statements, eg. for an if expression or a fallible call, it is evaluated into a
+// temporary before them. mark is the number of pre-statements when it was generated.
+func (g *Generator) evaluateBefore(node Node, expr string, typ Type, mark int) string {
+	if len(g.preStatements) == mark {
+		return expr
+	}
+	switch node.(type) {
+	case *NumNode, *StringLiteralNode, *BoolNode:
+		return expr
+	}
+	g.tmpVarCount++
+	tmpVar := fmt.Sprintf("___operand%d", g.tmpVarCount)
+	declaration := fmt.Sprintf("%s := %s", tmpVar, expr)
+	if typ != (NoCoercion{}) {
+		declaration = fmt.Sprintf("var %s %s = %s", tmpVar, g.codegenType(typ), expr)
+	}
+	g.preStatements = slices.Insert(g.preStatements, mark, declaration)
+	return tmpVar
+}
+
+// Generates a list of expressions, eg. arguments, keeping them evaluated in order
+func (g *Generator) codegenInOrder(nodes []Node, types []Type) []string {
+	var exprs []string
+	var marks []int
+	for i, node := range nodes {
+		exprs = append(exprs, g.codegenExpr(node, types[i]))
+		marks = append(marks, len(g.preStatements))
+	}
+	for i := len(exprs) - 2; i >= 0; i-- {
+		exprs[i] = g.evaluateBefore(nodes[i], exprs[i], types[i], marks[i])
+	}
+	return exprs
+}
+
+// The operands of arithmetic operators are generated as their common numeric type,
+// and the result is coerced afterwards.
+func (g *Generator) codegenArithmetic(node *BinOpNode, coercion Type) string {
+	left := g.codegenWithParens(node.left, node, node.operandType)
+	mark := len(g.preStatements)
+	right := g.codegenWithParens(node.right, node, node.operandType)
+	left = g.evaluateBefore(node.left, left, node.operandType, mark)
+	isFloat := node.operandType == (TypeFloat{})
+
+	var result string
+	switch node.token.kind {
+	case Modulo:
+		if isFloat {
+			g.addImport("math")
+			result = fmt.Sprintf("math.Mod(%s, %s)", left, right)
+		} else {
+			result = fmt.Sprintf("%s %% %s", left, right)
+		}
+	case Power:
+		if isFloat {
+			g.addImport("math")
+			result = fmt.Sprintf("math.Pow(%s, %s)", left, right)
+		} else {
+			// Computed exactly, math.Pow loses precision above 2^53
+			g.addPreludeFunction("intPower")
+			result = fmt.Sprintf("___intPower(%s, %s, %s)", left, right, g.location(node.token))
+		}
+	case IntDiv:
+		if isFloat {
+			g.addImport("math")
+			result = fmt.Sprintf("int(math.Trunc(%s / %s))", left, right)
+		} else {
+			result = fmt.Sprintf("%s / %s", left, right)
+		}
+	case BitXor:
+		result = fmt.Sprintf("%s ^ %s", left, right)
+	default:
+		result = fmt.Sprintf("%s %s %s", left, node.token.str, right)
+	}
+	return g.coerce(result, node.typ, coercion, CoercionModeDefault, node)
+}
+
 func (g *Generator) codegenWithParens(node Node, parent Node, coercion Type) string {
 	result := g.codegenExpr(node, coercion)
 
@@ -276,6 +622,11 @@ func (g *Generator) codegenWithParens(node Node, parent Node, coercion Type) str
 				needsParens = true
 			}
 		}
+
+		// Go gives all comparisons the same precedence, eg. `(a > b) == (c > d)`
+		if childOp.isComparison() && parentOp.isComparison() {
+			needsParens = true
+		}
 	}
 
 	if needsParens {
@@ -293,7 +644,111 @@ func (g *Generator) codegenAssignExpr(node *AssignNode, coercion Type) string {
 	return g.codegenVar(node.left.(*VarNode), coercion)
 }
 
+func (g *Generator) codegenIndexedAssign(node *AssignNode) string {
+	lhs := node.left.(*IndexedVarNode)
+	if _, found := g.scope.lookupSymbol(lhs.token.str); !found {
+		panic("Codegen of non-defined symbol in assignment")
+	}
+
+	switch t := containerType(g.scope, lhs).(type) {
+	case TypeMap:
+		container := g.codegenContainer(lhs)
+		return fmt.Sprintf(
+			"%s[%s] = %s",
+			container,
+			g.codegenExpr(lhs.index, t.KeyType),
+			g.codegenExpr(node.right, t.ValueType),
+		)
+	case TypeSlice:
+		// Assigning to a range replaces those elements, which may change the length of the
+		// slice, so the new slice is stored where the old one was, eg. in grid[1] for grid[1][2..]
+		if _, isRange := lhs.index.(*RangeNode); isRange {
+			g.addPreludeFunction("replaceRange")
+			target := lhs.token.str
+			if lhs.container != nil {
+				target = g.codegenAssignTarget(lhs.container)
+			}
+			return fmt.Sprintf(
+				"%s = ___replaceRange(%s, %s, %s)",
+				target,
+				target,
+				g.codegenExpr(node.right, t),
+				g.codegenRangeBounds(lhs, target),
+			)
+		}
+		container := g.codegenContainer(lhs)
+		return fmt.Sprintf(
+			"%s[%s] = %s",
+			container,
+			g.codegenIndex(lhs, container),
+			g.codegenExpr(node.right, t.ElementType),
+		)
+	default:
+		panic("UNREACHABLE: Element assignment to non-indexable type")
+	}
+}
+
+// Appends to a slice or string, used by both append() and `+=`. Appending a slice of
+// the same type appends all its elements.
+func (g *Generator) codegenAppend(dest string, destType Type, value Node, valueType Type) string {
+	switch t := destType.(type) {
+	case TypeSlice:
+		if _, isSlice := valueType.(TypeSlice); isSlice && valueType != t.ElementType {
+			return fmt.Sprintf("%s = append(%s, %s...)", dest, dest, g.codegenExpr(value, t))
+		}
+		return fmt.Sprintf("%s = append(%s, %s)", dest, dest, g.codegenExpr(value, t.ElementType))
+	case TypeString:
+		return fmt.Sprintf("%s += %s", dest, g.codegenExpr(value, TypeString{}))
+	default:
+		panic("UNREACHABLE: Append to non-appendable type")
+	}
+}
+
+// Returns the target of an assignment without any coercion. Map elements are
+// assigned directly, so missing keys start from the zero value.
+func (g *Generator) codegenAssignTarget(node Node) string {
+	switch n := node.(type) {
+	case *VarNode:
+		return n.token.str
+	case *IndexedVarNode:
+		container := g.codegenContainer(n)
+		if mapType, isMap := containerType(g.scope, n).(TypeMap); isMap {
+			return fmt.Sprintf("%s[%s]", container, g.codegenExpr(n.index, mapType.KeyType))
+		}
+		return fmt.Sprintf("%s[%s]", container, g.codegenIndex(n, container))
+	case *FieldAccessNode:
+		return g.codegenFieldAccess(n, NoCoercion{})
+	default:
+		panic("UNREACHABLE: Invalid assignment target")
+	}
+}
+
+// Compound assignments update map elements in place, so a missing key starts from the
+// zero value, eg. `counts[word] += 1`. Reading a missing key is still a runtime error, also
+// in the long form `counts[word] = counts[word] + 1`.
+func (g *Generator) codegenCompoundAssign(node *CompoundAssignNode) string {
+	target := g.codegenAssignTarget(node.left)
+	switch node.targetType.(type) {
+	case TypeSlice, TypeString:
+		return g.codegenAppend(target, node.targetType, node.right, node.valueType)
+	}
+
+	value := g.codegenExpr(node.right, node.targetType)
+	if node.token.kind == ModuloAssign && node.targetType == (TypeFloat{}) {
+		g.addImport("math")
+		return fmt.Sprintf("%s = math.Mod(%s, %s)", target, target, value)
+	}
+	return fmt.Sprintf("%s %s %s", target, node.token.str, value)
+}
+
 func (g *Generator) codegenAssign(node *AssignNode) string {
+	switch lhs := node.left.(type) {
+	case *IndexedVarNode:
+		return g.codegenIndexedAssign(node)
+	case *FieldAccessNode:
+		return fmt.Sprintf("%s = %s", g.codegenFieldAccess(lhs, NoCoercion{}), g.codegenExpr(node.right, lhs.typ))
+	}
+
 	opStr := "="
 	if node.declaration {
 		opStr = ":="
@@ -319,6 +774,57 @@ func (g *Generator) codegenAssign(node *AssignNode) string {
 	)
 }
 
+func (g *Generator) codegenTupleLiteral(node *TupleLiteralNode, coercion Type) string {
+	// Elements are coerced individually, so that eg. `return 1, 2` fits a function returning `(float, int)`
+	tupleType := node.typ
+	if coercedType, isTuple := coercion.(TypeTuple); isTuple && coercedType.Size == tupleType.Size {
+		tupleType = coercedType
+	}
+	var elements []string
+	for i, element := range node.elements {
+		elements = append(elements, g.codegenExpr(element, tupleType.Elements[i]))
+	}
+	tuple := fmt.Sprintf("%s{%s}", g.codegenType(tupleType), strings.Join(elements, ", "))
+	return g.coerce(tuple, tupleType, coercion, CoercionModeDefault, node)
+}
+
+// Assigns a tuple to a temporary variable and unpacks its fields, eg. `count, total = stats(values)`
+func (g *Generator) codegenDestructure(node *DestructureNode) string {
+	g.tmpVarCount++
+	tupleVar := fmt.Sprintf("___tuple%d", g.tmpVarCount)
+
+	lines := []string{fmt.Sprintf("%s := %s", tupleVar, g.codegenExpr(node.right, node.tupleType))}
+	for i, target := range node.targets {
+		name := target.token.str
+		if name == "_" {
+			continue
+		}
+		symbol, _ := g.scope.lookupSymbol(name)
+		if node.declarations[i] {
+			lines = append(lines, g.indent(fmt.Sprintf("%s := %s.V%d", name, tupleVar, i)))
+		} else {
+			value := g.coerce(fmt.Sprintf("%s.V%d", tupleVar, i), node.tupleType.Elements[i], symbol.typ, CoercionModeDefault, node)
+			lines = append(lines, g.indent(fmt.Sprintf("%s = %s", name, value)))
+		}
+		if !symbol.used {
+			lines = append(lines, g.indent(fmt.Sprintf("_ = %s", name)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unpacks a tuple control variable at the start of a loop body, eg. `for pairs -> (name, count)`
+func (g *Generator) addDestructureInitStatements(tupleVar string, targets []VarNode) {
+	g.addInitStatement(fmt.Sprintf("_ = %s", tupleVar))
+	for i, target := range targets {
+		if target.token.str == "_" {
+			continue
+		}
+		g.addInitStatement(fmt.Sprintf("%s := %s.V%d", target.token.str, tupleVar, i))
+		g.addInitStatement(fmt.Sprintf("_ = %s", target.token.str))
+	}
+}
+
 func (g *Generator) codegenCompoundStatement(node *CompoundStatementNode) string {
 	prevScope := g.scope
 	g.scope = node.scope
@@ -338,6 +844,9 @@ func (g *Generator) codegenCompoundStatement(node *CompoundStatementNode) string
 	}
 	g.finalStatements = nil
 
+	// The pre-statements of the statement owning this scope must not be mixed up with those of its body
+	outerPreStatements := g.preStatements
+
 	for _, child := range node.children {
 		if !g.ignorePreStatements {
 			g.preStatements = nil
@@ -367,6 +876,10 @@ func (g *Generator) codegenCompoundStatement(node *CompoundStatementNode) string
 	// Add final statements to the end of the scope
 	statements = append(statements, finals...)
 
+	if !g.ignorePreStatements {
+		g.preStatements = outerPreStatements
+	}
+
 	statementsString := strings.Join(statements, "\n")
 	g.indentLevel--
 	g.scope = prevScope
@@ -374,6 +887,43 @@ func (g *Generator) codegenCompoundStatement(node *CompoundStatementNode) string
 	return fmt.Sprintf("{\n%s\n%s", statementsString, g.indent("}"))
 }
 
+// Lambdas become Go function literals. Pre-statements of the body, eg. from fallible
+// calls, are kept inside the function literal.
+func (g *Generator) codegenLambda(node *LambdaNode) string {
+	var params []string
+	for _, param := range node.parameters {
+		params = append(params, g.codegenParameter(&param))
+	}
+	signature := strings.TrimSpace(fmt.Sprintf("func(%s) %s", strings.Join(params, ", "), g.codegenType(node.typ.ReturnType)))
+
+	preStatements := g.preStatements
+	g.preStatements = nil
+	prevScope := g.scope
+	g.scope = node.scope
+	g.indentLevel++
+
+	var body string
+	if node.typ.ReturnType == (TypeVoid{}) {
+		body = g.codegenExpr(node.body, NoCoercion{})
+	} else {
+		body = "return " + g.codegenExpr(node.body, node.typ.ReturnType)
+	}
+	var statements []string
+	for _, preStatement := range g.preStatements {
+		statements = append(statements, g.indent(preStatement))
+	}
+	statements = append(statements, g.indent(body))
+
+	g.indentLevel--
+	g.scope = prevScope
+	g.preStatements = preStatements
+
+	if len(statements) == 1 {
+		return fmt.Sprintf("%s { %s }", signature, body)
+	}
+	return fmt.Sprintf("%s {\n%s\n%s", signature, strings.Join(statements, "\n"), g.indent("}"))
+}
+
 func (g *Generator) codegenType(typ Type) string {
 	switch t := typ.(type) {
 	case TypeInt:
@@ -386,6 +936,29 @@ func (g *Generator) codegenType(typ Type) string {
 		return "bool"
 	case TypeSlice:
 		return "[]"+g.codegenType(t.GetElementType())
+	case TypeSet:
+		return "map["+g.codegenType(t.ElementType)+"]struct{}"
+	case TypeMap:
+		return "map["+g.codegenType(t.KeyType)+"]"+g.codegenType(t.ValueType)
+	case TypeRecord:
+		return t.Name
+	case TypeEnum:
+		return t.Name
+	case TypeTuple:
+		g.addPreludeFunction("tuples")
+		var elementTypes []string
+		for _, elementType := range t.ElementTypes() {
+			elementTypes = append(elementTypes, g.codegenType(elementType))
+		}
+		return fmt.Sprintf("___Tuple%d[%s]", t.Size, strings.Join(elementTypes, ", "))
+	case TypeFunction:
+		var paramTypes []string
+		for _, paramType := range t.ParamTypes() {
+			paramTypes = append(paramTypes, g.codegenType(paramType))
+		}
+		return strings.TrimSpace(fmt.Sprintf("func(%s) %s", strings.Join(paramTypes, ", "), g.codegenType(t.ReturnType)))
+	case TypeParam:
+		return t.Name
 	case TypeVoid:
 		return ""
 	default:
@@ -413,6 +986,53 @@ func (g *Generator) codegenParameterList(node *ParameterListNode) string {
 }
 
 func (g *Generator) codegenFunction(node *FunctionNode) string {
+	signature, body := g.codegenFunctionParts(node)
+	typeParams := ""
+	if len(node.typeParams) > 0 {
+		var params []string
+		for _, name := range node.typeParams {
+			params = append(params, name+" "+g.codegenConstraint(node.typeParamConstraints[name]))
+		}
+		typeParams = fmt.Sprintf("[%s]", strings.Join(params, ", "))
+	}
+	return fmt.Sprintf("func %s%s%s %s", node.token.str, typeParams, signature, body)
+}
+
+func (g *Generator) codegenConstraint(constraint TypeConstraint) string {
+	switch constraint {
+	case ComparableConstraint:
+		return "comparable"
+	case OrderedConstraint:
+		g.addImport("cmp")
+		return "cmp.Ordered"
+	default:
+		return "any"
+	}
+}
+
+// Functions declared inside other functions become Go closures. The variable is declared
+// before the closure is assigned, so that the function can call itself.
+func (g *Generator) codegenNestedFunction(node *FunctionNode) string {
+	// Post statements belong to the enclosing function
+	postStatements := g.postStatements
+	g.postStatements = nil
+	signature, body := g.codegenFunctionParts(node)
+	g.postStatements = postStatements
+
+	name := node.token.str
+	return fmt.Sprintf("%s\n%s\n%s",
+		strings.TrimSpace(fmt.Sprintf("var %s func%s", name, signature)),
+		g.indent(fmt.Sprintf("%s = func%s %s", name, signature, body)),
+		g.indent(fmt.Sprintf("_ = %s", name)),
+	)
+}
+
+// Returns the signature of a function, eg. `(a int, b string) (int, error)`, and its body
+func (g *Generator) codegenFunctionParts(node *FunctionNode) (string, string) {
+	if _, isGenerator := node.returnType.(TypeGenerator); isGenerator {
+		return g.codegenGeneratorFunction(node)
+	}
+
 	returns := g.codegenReturnType(node.returnType)
 
 	if node.fallible {
@@ -429,7 +1049,77 @@ func (g *Generator) codegenFunction(node *FunctionNode) string {
 		g.addPostStatement("return nil")
 	}
 	bodyStr := g.codegenCompoundStatement(node.body.(*CompoundStatementNode))
-	return fmt.Sprintf("func %s(%s) %s %s",	node.token.str, paramStr, returns,bodyStr)
+	return fmt.Sprintf("(%s) %s", paramStr, returns), bodyStr
+}
+
+// Generators become functions returning a Go iterator, so they can be consumed with
+// range-over-func. Fallible generators yield an error alongside each value.
+func (g *Generator) codegenGeneratorFunction(node *FunctionNode) (string, string) {
+	g.addImport("iter")
+	elementType := g.codegenType(node.returnType.(TypeGenerator).ElementType)
+	returns := fmt.Sprintf("iter.Seq[%s]", elementType)
+	yieldParams := elementType
+	if node.fallible {
+		returns = fmt.Sprintf("iter.Seq2[%s, error]", elementType)
+		yieldParams = elementType + ", error"
+	}
+	paramStr := g.codegenParameterList(node.parameters.(*ParameterListNode))
+
+	g.indentLevel++
+	bodyStr := g.codegenCompoundStatement(node.body.(*CompoundStatementNode))
+	iterator := g.indent(fmt.Sprintf("return func(yield func(%s) bool) %s", yieldParams, bodyStr))
+	g.indentLevel--
+	return fmt.Sprintf("(%s) %s", paramStr, returns), fmt.Sprintf("{\n%s\n%s", iterator, g.indent("}"))
+}
+
+// Consumes a user defined generator, eg. `records(path) -> row, idx { }`
+func (g *Generator) codegenGeneratorCall(node *FunctionCallNode, symbol *Symbol, functionCall string) string {
+	g.tmpVarCount++
+	counterVar := fmt.Sprintf("___counter%d", g.tmpVarCount)
+
+	// Bodies are separate scopes, keep the pre-statements of the call itself
+	preStatements := g.preStatements
+
+	genVar := g.codegenVar(&node.generatorVar, NoCoercion{})
+	loopVars := genVar
+	if symbol.fallible {
+		loopVars = genVar + ", err"
+		returnScope := g.scope.closestReturningScope()
+		var errorHandling string
+		if node.errorBody != nil {
+			// The generator stops after failing, leave the loop after handling the error
+			g.addFinalStatement("break")
+			errorHandling = fmt.Sprintf("if err != nil %s", g.codegenCompoundStatement(node.errorBody.(*CompoundStatementNode)))
+		} else if returnScope.fallible {
+			errorHandling = fmt.Sprintf("if err != nil { %s }", g.propagateError(returnScope, "err"))
+		} else {
+			g.addPreludeFunction("handleNonPropagatableError")
+			errorHandling = "___handleNonPropagatableError(err)"
+		}
+		g.addInitStatement(errorHandling)
+	}
+	g.addInitStatement(fmt.Sprintf("_ = %s", genVar))
+	if len(node.generatorDestructure) > 0 {
+		g.addDestructureInitStatements(genVar, node.generatorDestructure)
+	}
+
+	idxInitCode := ""
+	if node.generatorHasIdx {
+		idxInitCode = fmt.Sprintf("%s := -1\n", counterVar)
+		g.addInitStatement(fmt.Sprintf("%s++", counterVar))
+		genIdxVar := g.codegenVar(&node.generatorIdxVar, NoCoercion{})
+		g.addInitStatement(fmt.Sprintf("%s := %s", genIdxVar, counterVar))
+		g.addInitStatement(fmt.Sprintf("_ = %s", genIdxVar))
+	}
+
+	body := g.codegenCompoundStatement(node.generatorBody.(*CompoundStatementNode))
+	g.preStatements = preStatements
+
+	loop := g.codegenLabel(node.generatorLabel) + fmt.Sprintf("for %s := range %s %s", loopVars, functionCall, body)
+	if idxInitCode != "" {
+		loop = idxInitCode + g.indent(loop)
+	}
+	return loop
 }
 
 func (g *Generator) codegenFunctionCall(node *FunctionCallNode, coercion Type) string {
@@ -441,87 +1131,177 @@ func (g *Generator) codegenFunctionCall(node *FunctionCallNode, coercion Type) s
 
 	// FIXME: Make print a builtin!
 	if node.name == "print" {
-		var argumentStrings []string
+		var arguments []Node
+		var types []Type
 		for _, argument := range node.arguments {
-			argumentStrings = append(argumentStrings, g.codegenExpr(argument.(*ArgumentNode).expr, NoCoercion{}))
+			arguments = append(arguments, argument.(*ArgumentNode).expr)
+			types = append(types, NoCoercion{})
 		}
-		return fmt.Sprintf("fmt.Println(%s)", strings.Join(argumentStrings, ", "))
+		return fmt.Sprintf("fmt.Println(%s)", strings.Join(g.codegenInOrder(arguments, types), ", "))
 
 	} else {
 		symbol, _ := g.scope.lookupSymbol(node.name)
 
+		// Record construction, eg. `Point(1, y=2)`
+		if symbol.category == RecordSymbol {
+			var fieldStrings []string
+			for _, field := range symbol.paramsNode.parameters {
+				fieldStrings = append(fieldStrings, fmt.Sprintf("%s: %s", field.name, g.codegenExpr(node.resolvedArgs[field.name].expr, field.typ)))
+			}
+			record := fmt.Sprintf("%s{%s}", node.name, strings.Join(fieldStrings, ", "))
+			return g.coerce(record, symbol.typ, coercion, CoercionModeDefault, node)
+		}
+
+		// Enum values, eg. `Shape.Circle(2.0)`, are constructed like records of the variant
+		if symbol.category == VariantSymbol {
+			var fieldStrings []string
+			for _, field := range symbol.paramsNode.parameters {
+				fieldStrings = append(fieldStrings, fmt.Sprintf("%s: %s", field.name, g.codegenExpr(node.resolvedArgs[field.name].expr, field.typ)))
+			}
+			// Converted to the enum, so variables holding it can be given any other variant
+			variant := fmt.Sprintf("%s(%s{%s})", g.codegenType(symbol.typ), variantTypeName(node.name), strings.Join(fieldStrings, ", "))
+			return g.coerce(variant, symbol.typ, coercion, CoercionModeDefault, node)
+		}
+
 		// Codegen all arguements
-		var argumentStrings []string
-		for _, param := range symbol.paramsNode.parameters {
-			argumentStrings = append(argumentStrings, g.codegenExpr(node.resolvedArgs[param.name].expr, param.typ))
+		parameters, returnType, _ := symbol.signature()
+		isGenericCall := len(symbol.typeParams) > 0
+		if isGenericCall {
+			returnType = substituteTypeParams(returnType, node.typeArgs)
 		}
+		var arguments []Node
+		var types []Type
+		for _, param := range parameters {
+			paramType := param.typ
+			if isGenericCall {
+				paramType = substituteTypeParams(paramType, node.typeArgs)
+			}
+			arguments = append(arguments, node.resolvedArgs[param.name].expr)
+			types = append(types, paramType)
+		}
+		argumentStrings := g.codegenInOrder(arguments, types)
+
+		// Codegen the final call, generic functions are instantiated explicitly
+		name := node.name
+		if isGenericCall {
+			var typeArgs []string
+			for _, typeParam := range symbol.typeParams {
+				typeArgs = append(typeArgs, g.codegenType(node.typeArgs[typeParam]))
+			}
+			name += "[" + strings.Join(typeArgs, ", ") + "]"
+		}
+		functionCall := fmt.Sprintf("%s(%s)", name, strings.Join(argumentStrings, ", "))
 
-		// Codegen the final call
-		functionCall := fmt.Sprintf("%s(%s)", node.name, strings.Join(argumentStrings, ", "))
+		if node.generatorBody != nil {
+			return g.codegenGeneratorCall(node, &symbol, functionCall)
+		}
+
+		// The iterator itself is consumed by a higher-order builtin
+		if _, isGenerator := returnType.(TypeGenerator); isGenerator && node.streamed {
+			return functionCall
+		}
 
 		// For call to non-fallible function, just return the call
 		if !symbol.fallible {
-			return g.coerce(functionCall, symbol.typ, coercion, CoercionModeDefault, node)
+			return g.coerce(functionCall, returnType, coercion, CoercionModeDefault, node)
 		}
 
+		return g.codegenFallibleCall(node, functionCall, returnType, coercion)
+	}
+}
 
-		// For calls to fallible function, things becaome a bit more complicated...
-		returnScope := g.scope.closestReturningScope()
-		lhsVars := []string{"err"}
-		onErrReturnVars := []string{"err"}
-		replacementCode := ""
-		if symbol.typ != (TypeVoid{}) {
-			replacementVar := g.getReplacementVarName(node.name)
-			lhsVars = []string{replacementVar, "err"}
-			replacementCode = g.coerce(replacementVar, symbol.typ, coercion, CoercionModeDefault, node)
-			if returnScope.returnType != (TypeVoid{}) {
-				onErrReturnVars = []string{g.nilValue(returnScope.returnType), "err"}
-			}
-		}
+// For calls to fallible functions, things become a bit more complicated... The call is
+// made in a pre-statement followed by the error handling, and its result is replaced by
+// a temporary variable.
+func (g *Generator) codegenFallibleCall(node *FunctionCallNode, functionCall string, returnType Type, coercion Type) string {
+	lhsVars := []string{"err"}
+	replacementCode := ""
+	if returnType != (TypeVoid{}) {
+		replacementVar := g.getReplacementVarName(node.name)
+		lhsVars = []string{replacementVar, "err"}
+		replacementCode = g.coerce(replacementVar, returnType, coercion, CoercionModeDefault, node)
+	}
 
-		// Generate error-catching function call pre-statement
-		g.addPreStatement(fmt.Sprintf("%s := %s", strings.Join(lhsVars, ", "), functionCall))
+	// Generate error-catching function call pre-statement
+	g.addPreStatement(fmt.Sprintf("%s := %s", strings.Join(lhsVars, ", "), functionCall))
 
-		// Generate error handling prestatement
-		if node.errorBody != nil {
-			g.ignorePreStatements = true
-			g.addPreStatement(fmt.Sprintf("if err != nil %s", g.codegenCompoundStatement(node.errorBody.(*CompoundStatementNode))))
-			g.ignorePreStatements = false
-		} else if returnScope.fallible {
-			g.addPreStatement(fmt.Sprintf("if err != nil { return %s }", strings.Join(onErrReturnVars, ", ")))
-		} else {
-			g.addPreludeFunction("handleNonPropagatableError")
-			g.addPreStatement("___handleNonPropagatableError(err)")
-		}
+	// Generate error handling prestatement
+	g.addPreStatement(fmt.Sprintf("if err != nil %s", g.codegenErrorHandling(node)))
+
+	return replacementCode
+}
 
-		return replacementCode
+// Generates the block run when a fallible call fails: the error body of the call if
+// it has one, otherwise the error is propagated or ends the program.
+func (g *Generator) codegenErrorHandling(node *FunctionCallNode) string {
+	returnScope := g.scope.closestReturningScope()
+	if node.errorBody != nil {
+		g.ignorePreStatements = true
+		block := g.codegenCompoundStatement(node.errorBody.(*CompoundStatementNode))
+		g.ignorePreStatements = false
+		return block
+	}
+	if returnScope.fallible {
+		return fmt.Sprintf("{ %s }", g.propagateError(returnScope, "err"))
 	}
+	g.addPreludeFunction("handleNonPropagatableError")
+	return "{ ___handleNonPropagatableError(err) }"
 }
 
 func (g *Generator) codegenBuiltinCall(node *FunctionCallNode, coercion Type) string {
 	builtin := builtins[node.name]
 
+	// Generators are consumed by higher-order builtins one value at a time
+	if list, hasList := node.resolvedArgs["list"]; hasList {
+		if _, isGenerator := list.typ.(TypeGenerator); isGenerator {
+			return g.codegenSequenceBuiltin(node, coercion)
+		}
+	}
+
 	callStr := ""
 	switch builtin.name {
 
 	case "len":
-		callStr = fmt.Sprintf("len(%s)", g.codegenExpr(node.resolvedArgs["var"].expr, NoCoercion{}))
+		// The length of a string is its number of characters, len_bytes() gives the number of bytes
+		arg := node.resolvedArgs["var"]
+		if arg.typ == (TypeString{}) {
+			g.addImport("unicode/utf8")
+			callStr = fmt.Sprintf("utf8.RuneCountInString(%s)", g.codegenExpr(arg.expr, NoCoercion{}))
+		} else {
+			callStr = fmt.Sprintf("len(%s)", g.codegenExpr(arg.expr, NoCoercion{}))
+		}
+
+	case "int", "float", "str", "bool":
+		arg := node.resolvedArgs["value"]
+		value := g.codegenExpr(arg.expr, NoCoercion{})
+		if _, isLiteral := arg.expr.(*NumNode); isLiteral && arg.typ == (TypeFloat{}) && builtin.returnType == (TypeInt{}) {
+			// Go does not allow truncating constants, eg. `int(3.9)`
+			g.addImport("math")
+			value = fmt.Sprintf("math.Trunc(%s)", value)
+		}
+		callStr = g.coerce(value, arg.typ, builtin.returnType, CoercionModeDefault, node)
+
+	case "to_int":
+		g.addPreludeFunction("parseInt")
+		call := fmt.Sprintf("___parseInt(%s)", g.codegenExpr(node.resolvedArgs["str"].expr, TypeString{}))
+		return g.codegenFallibleCall(node, call, builtin.returnType, coercion)
+
+	case "to_float":
+		g.addPreludeFunction("parseFloat")
+		call := fmt.Sprintf("___parseFloat(%s)", g.codegenExpr(node.resolvedArgs["str"].expr, TypeString{}))
+		return g.codegenFallibleCall(node, call, builtin.returnType, coercion)
+
+	case "len_bytes":
+		callStr = fmt.Sprintf("len(%s)", g.codegenExpr(node.resolvedArgs["str"].expr, TypeString{}))
+
+	case "bytes":
+		g.addPreludeFunction("stringBytes")
+		callStr = fmt.Sprintf("___stringBytes(%s)", g.codegenExpr(node.resolvedArgs["str"].expr, TypeString{}))
 
 	case "append":
 		destArg := node.resolvedArgs["dest"]
 		dest := g.codegenVar(destArg.expr.(*VarNode), NoCoercion{})
-		switch destArg.typ.(type) {
-		case TypeSlice:
-			return fmt.Sprintf("%s = append(%s, %s)",
-				dest, dest,
-				g.codegenExpr(
-					node.resolvedArgs["var"].expr,
-					destArg.typ.(IterableType).GetElementType(),
-				),
-			)
-		case TypeString:
-			return fmt.Sprintf("%s += %s", dest, g.codegenExpr(node.resolvedArgs["var"].expr, TypeString{}))
-		}
+		return g.codegenAppend(dest, destArg.typ, node.resolvedArgs["var"].expr, nil)
 
 	case "add":
 		destArg := node.resolvedArgs["dest"]
@@ -548,10 +1328,29 @@ func (g *Generator) codegenBuiltinCall(node *FunctionCallNode, coercion Type) st
 		)
 
 	case "del":
-		set := node.resolvedArgs["set"]
+		container := node.resolvedArgs["container"]
 		return fmt.Sprintf("delete(%s, %s)",
-			g.codegenVar(set.expr.(*VarNode), NoCoercion{}),
-			g.codegenExpr(node.resolvedArgs["value"].expr, set.typ.(IterableType).GetElementType()),
+			g.codegenVar(container.expr.(*VarNode), NoCoercion{}),
+			g.codegenExpr(node.resolvedArgs["value"].expr, container.typ.(IterableType).GetElementType()),
+		)
+
+	case "keys":
+		g.addImport("maps")
+		g.addImport("slices")
+		callStr = fmt.Sprintf("slices.Sorted(maps.Keys(%s))", g.codegenExpr(node.resolvedArgs["map"].expr, NoCoercion{}))
+
+	case "values":
+		g.addPreludeFunction("sortedValues")
+		callStr = fmt.Sprintf("___sortedValues(%s)", g.codegenExpr(node.resolvedArgs["map"].expr, NoCoercion{}))
+
+	case "get":
+		g.addPreludeFunction("mapGetDefault")
+		mapArg := node.resolvedArgs["map"]
+		mapType := mapArg.typ.(TypeMap)
+		callStr = fmt.Sprintf("___mapGetDefault(%s, %s, %s)",
+			g.codegenExpr(mapArg.expr, NoCoercion{}),
+			g.codegenExpr(node.resolvedArgs["key"].expr, mapType.KeyType),
+			g.codegenExpr(node.resolvedArgs["default"].expr, mapType.ValueType),
 		)
 
 	case "to_set":
@@ -621,10 +1420,39 @@ func (g *Generator) codegenBuiltinCall(node *FunctionCallNode, coercion Type) st
 		)
 
 	case "read":
+		g.addPreludeFunction("openFile")
+		g.addPreludeFunction("fileError")
+		g.addPreludeFunction("newScanner")
+		g.addPreludeFunction("scanError")
+		path := g.codegenExpr(node.resolvedArgs["path"].expr, TypeString{})
+		chomp := g.codegenExpr(node.resolvedArgs["chomp"].expr, TypeBool{})
+
+		// Higher-order builtins consume the lines one at a time
+		if node.streamed {
+			g.addPreludeFunction("readSeq")
+			if sep := node.resolvedArgs["sep"].expr.(*StringLiteralNode); sep.token.str != "" {
+				g.addPreludeFunction("readFieldsSeq")
+				return fmt.Sprintf("___readFieldsSeq(%s, %s, %s)", path, chomp, g.codegenStringLiteral(sep, NoCoercion{}))
+			}
+			return fmt.Sprintf("___readSeq(%s, %s)", path, chomp)
+		}
+
+		// Without `->` all lines are read into a slice
+		if node.generatorBody == nil {
+			g.addPreludeFunction("readLines")
+			call := fmt.Sprintf("___readLines(%s, %s)", path, chomp)
+			if sep := node.resolvedArgs["sep"].expr.(*StringLiteralNode); sep.token.str != "" {
+				g.addPreludeFunction("readFields")
+				call = fmt.Sprintf("___readFields(%s, %s, %s)", path, chomp, g.codegenStringLiteral(sep, NoCoercion{}))
+			}
+			return g.codegenFallibleCall(node, call, node.resolvedReturnType, coercion)
+		}
+
 		g.tmpVarCount++
-		g.addImport("os")
 		g.addImport("bufio")
-		path := g.codegenExpr(node.resolvedArgs["path"].expr, TypeString{})
+
+		// The error handling is generated before the init statements of the body are added
+		errorHandling := g.codegenErrorHandling(node)
 		genVar :=  g.codegenVar(&node.generatorVar, NoCoercion{})
 		genVarSymbol, found := node.generatorBody.(*CompoundStatementNode).scope.lookupSymbol(genVar)
 		if !found {
@@ -653,39 +1481,172 @@ func (g *Generator) codegenBuiltinCall(node *FunctionCallNode, coercion Type) st
 			idxInitCode = fmt.Sprintf("___counter%d := -1", g.tmpVarCount)
 			g.addInitStatement(fmt.Sprintf("___counter%d++", g.tmpVarCount))
 			g.addInitStatement(fmt.Sprintf("%s := ___counter%d", genIdxVar, g.tmpVarCount))
+			g.addInitStatement(fmt.Sprintf("_ = %s", genIdxVar))
 		}
 
+		g.indentLevel++
 		body := g.codegenCompoundStatement(node.generatorBody.(*CompoundStatementNode))
 		readCodeList := []string{
-			fmt.Sprintf("___file%d, err := os.Open(%s)", g.tmpVarCount, path),
-			g.indent("if err != nil {"),
-			g.indent("    panic(\"Open fail\")"),
-			g.indent("}"),
+			fmt.Sprintf("if ___file%d, err := ___openFile(%s); err != nil %s else {", g.tmpVarCount, path, errorHandling),
 			g.indent(fmt.Sprintf("defer ___file%d.Close()", g.tmpVarCount)),
 			g.indent(idxInitCode),
-			g.indent(fmt.Sprintf("___scanner%d := bufio.NewScanner(___file%d)", g.tmpVarCount, g.tmpVarCount)),
+			g.indent(fmt.Sprintf("___scanner%d := ___newScanner(___file%d)", g.tmpVarCount, g.tmpVarCount)),
 			g.indent(fmt.Sprintf("___chomp%d := false", g.tmpVarCount)),
-			g.indent(fmt.Sprintf("if %s { ___chomp%d = true }", g.codegenExpr(node.resolvedArgs["chomp"].expr, TypeBool{}), g.tmpVarCount)),
-			g.indent(fmt.Sprintf("for ___scanner%d.Scan()", g.tmpVarCount)),
+			g.indent(fmt.Sprintf("if %s { ___chomp%d = true }", chomp, g.tmpVarCount)),
+			g.indent(g.codegenLabel(node.generatorLabel) + fmt.Sprintf("for ___scanner%d.Scan() %s", g.tmpVarCount, body)),
+			g.indent(fmt.Sprintf("if err := ___scanError(___file%d, ___scanner%d); err != nil %s", g.tmpVarCount, g.tmpVarCount, errorHandling)),
 		}
-		readCode := fmt.Sprintf("%s %s", strings.Join(readCodeList, "\n"), body)
+		g.indentLevel--
+		readCode := fmt.Sprintf("%s\n%s", strings.Join(readCodeList, "\n"), g.indent("}"))
 
 		return readCode
 
+	case "map":
+		g.addPreludeFunction("mapSlice")
+		callStr = fmt.Sprintf("___mapSlice(%s, %s)",
+			g.codegenElements(node.resolvedArgs["list"]),
+			g.codegenExpr(node.resolvedArgs["f"].expr, NoCoercion{}),
+		)
+
+	case "filter":
+		list := node.resolvedArgs["list"]
+		prelude := "filterSlice"
+		if _, isSet := list.typ.(TypeSet); isSet {
+			prelude = "filterSet"
+		}
+		g.addPreludeFunction(prelude)
+		callStr = fmt.Sprintf("___%s(%s, %s)",
+			prelude,
+			g.codegenExpr(list.expr, NoCoercion{}),
+			g.codegenExpr(node.resolvedArgs["f"].expr, NoCoercion{}),
+		)
+
+	case "reduce":
+		g.addPreludeFunction("reduceSlice")
+		initial := node.resolvedArgs["initial"]
+		callStr = fmt.Sprintf("___reduceSlice(%s, %s, %s)",
+			g.codegenElements(node.resolvedArgs["list"]),
+			g.codegenExpr(node.resolvedArgs["f"].expr, NoCoercion{}),
+			g.codegenExpr(initial.expr, initial.typ),
+		)
+
+	case "any", "all":
+		prelude := builtin.name + "Slice"
+		g.addPreludeFunction(prelude)
+		callStr = fmt.Sprintf("___%s(%s, %s)",
+			prelude,
+			g.codegenElements(node.resolvedArgs["list"]),
+			g.codegenExpr(node.resolvedArgs["f"].expr, NoCoercion{}),
+		)
+
+	case "sort_by":
+		g.addPreludeFunction("sortBy")
+		callStr = fmt.Sprintf("___sortBy(%s, %s)",
+			g.codegenElements(node.resolvedArgs["list"]),
+			g.codegenExpr(node.resolvedArgs["key"].expr, NoCoercion{}),
+		)
+
 	case "slurp":
 		g.addPreludeFunction("slurpFile")
-		callStr = fmt.Sprintf("___slurpFile(%s)",
-			g.codegenExpr(node.resolvedArgs["path"].expr, TypeString{}),
-		)
+		g.addPreludeFunction("fileError")
+		call := fmt.Sprintf("___slurpFile(%s)", g.codegenExpr(node.resolvedArgs["path"].expr, TypeString{}))
+		return g.codegenFallibleCall(node, call, builtin.returnType, coercion)
 
 	default:
 		panic("Unimplemented bulitin")
 	}
-	return g.coerce(callStr, builtin.returnType, coercion, CoercionModeDefault, node)
+
+	returnType := builtin.returnType
+	if node.resolvedReturnType != nil {
+		returnType = node.resolvedReturnType
+	}
+	return g.coerce(callStr, returnType, coercion, CoercionModeDefault, node)
+}
+
+// Elements of the slice or set given to a higher-order builtin. Sets are passed in sorted
+// order, so the results do not depend on Go's map iteration order.
+func (g *Generator) codegenElements(arg ArgumentNode) string {
+	elements := g.codegenExpr(arg.expr, NoCoercion{})
+	if _, isSet := arg.typ.(TypeSet); isSet {
+		g.addImport("maps")
+		g.addImport("slices")
+		return fmt.Sprintf("slices.Sorted(maps.Keys(%s))", elements)
+	}
+	return elements
+}
+
+// Higher-order builtins on generators, eg. `read(path)?.filter(valid).map(score)`, pass
+// the values along as Go iterators, so they are never all held in memory. A failing
+// generator ends the iteration, its error is handled once the builtin has returned.
+func (g *Generator) codegenSequenceBuiltin(node *FunctionCallNode, coercion Type) string {
+	g.tmpVarCount++
+	errVar := fmt.Sprintf("___err%d", g.tmpVarCount)
+	seq, source := g.codegenSequence(node.resolvedArgs["list"].expr.(*FunctionCallNode), errVar)
+
+	var call string
+	switch node.name {
+	case "map", "filter":
+		g.addImport("slices")
+		g.addPreludeFunction(node.name + "Seq")
+		call = fmt.Sprintf("slices.Collect(___%sSeq(%s, %s))", node.name, seq, g.codegenExpr(node.resolvedArgs["f"].expr, NoCoercion{}))
+	case "reduce":
+		g.addPreludeFunction("reduceSeq")
+		initial := node.resolvedArgs["initial"]
+		call = fmt.Sprintf("___reduceSeq(%s, %s, %s)", seq, g.codegenExpr(node.resolvedArgs["f"].expr, NoCoercion{}), g.codegenExpr(initial.expr, initial.typ))
+	case "any", "all":
+		g.addPreludeFunction(node.name + "Seq")
+		call = fmt.Sprintf("___%sSeq(%s, %s)", node.name, seq, g.codegenExpr(node.resolvedArgs["f"].expr, NoCoercion{}))
+	case "sort_by":
+		g.addImport("slices")
+		g.addPreludeFunction("sortBy")
+		call = fmt.Sprintf("___sortBy(slices.Collect(%s), %s)", seq, g.codegenExpr(node.resolvedArgs["key"].expr, NoCoercion{}))
+	default:
+		panic("UNREACHABLE")
+	}
+
+	if source != nil {
+		result := g.getReplacementVarName(node.name)
+		g.addPreStatement(fmt.Sprintf("var %s error", errVar))
+		g.addPreStatement(fmt.Sprintf("%s := %s", result, call))
+		g.addPreStatement(fmt.Sprintf("if err := %s; err != nil %s", errVar, g.codegenErrorHandling(source)))
+		call = result
+	}
+	return g.coerce(call, node.resolvedReturnType, coercion, CoercionModeDefault, node)
+}
+
+// Returns the iterator of a generator consumed by a higher-order builtin, and the call
+// whose error ends it if it can fail. Its error is stored in errVar.
+func (g *Generator) codegenSequence(node *FunctionCallNode, errVar string) (string, *FunctionCallNode) {
+	if node.isBuiltin && (node.name == "map" || node.name == "filter") {
+		seq, source := g.codegenSequence(node.resolvedArgs["list"].expr.(*FunctionCallNode), errVar)
+		g.addPreludeFunction(node.name + "Seq")
+		return fmt.Sprintf("___%sSeq(%s, %s)", node.name, seq, g.codegenExpr(node.resolvedArgs["f"].expr, NoCoercion{})), source
+	}
+
+	seq := g.codegenExpr(node, NoCoercion{})
+	fallible := builtins[node.name].fallible
+	if !node.isBuiltin {
+		symbol, _ := g.scope.lookupSymbol(node.name)
+		fallible = symbol.fallible
+	}
+	if !fallible {
+		return seq, nil
+	}
+	g.addPreludeFunction("seqErrors")
+	return fmt.Sprintf("___seqErrors(%s, &%s)", seq, errVar), node
 }
 
 func (g *Generator) codegenReturn(node *ReturnNode) string {
 	returnScope := g.scope.closestReturningScope()
+
+	// Bare returns from void functions and generators
+	if _, isBare := node.expr.(*NoOpNode); isBare {
+		if _, isGenerator := returnScope.returnType.(TypeGenerator); !isGenerator && returnScope.fallible {
+			return "return nil"
+		}
+		return "return"
+	}
+
 	returnVal := g.codegenExpr(node.expr, returnScope.returnType)
 	if returnScope.fallible {
 		returnVal += ", nil"
@@ -704,14 +1665,17 @@ func (g *Generator) codegenFail(node *FailNode) string {
 	}
 
 	failureString := g.codegenExpr(node.expr, TypeString{})
+	g.addImport("errors")
+	return g.propagateError(returnScope, fmt.Sprintf("errors.New(%s)", failureString))
+}
 
-	if returnScope.returnType == (TypeVoid{}) {
-		return fmt.Sprintf("return errors.New(%s)", failureString)
+func (g *Generator) codegenYield(node *YieldNode) string {
+	returnScope := g.scope.closestReturningScope()
+	value := g.codegenExpr(node.expr, returnScope.returnType.(TypeGenerator).ElementType)
+	if returnScope.fallible {
+		return fmt.Sprintf("if !yield(%s, nil) { return }", value)
 	}
-
-	nilReturn := g.nilValue(returnScope.returnType)
-	g.addImport("errors")
-	return fmt.Sprintf("return %s,  errors.New(%s)", nilReturn, failureString)
+	return fmt.Sprintf("if !yield(%s) { return }", value)
 }
 
 func (g *Generator) codegenIf(node *IfNode) string {
@@ -732,7 +1696,7 @@ func (g *Generator) codegenIf(node *IfNode) string {
 	comparison := g.codegenExpr(node.comp, coerceType)
 
 	// Assignments in if statements generage prestatements that need to be added before the if statement
-	prestatements := strings.Join(g.preStatements, "\n")
+	prestatements := strings.Join(g.preStatements, "\n"+g.indent(""))
 	g.preStatements = nil
 
 	body := g.codegenCompoundStatement(node.body.(*CompoundStatementNode))
@@ -746,26 +1710,99 @@ func (g *Generator) codegenIf(node *IfNode) string {
 	)
 }
 
+// Returns the label to put in front of a loop, if any `break` or `continue` targets it
+func (g *Generator) codegenLabel(label *LoopLabel) string {
+	if label == nil || !label.used {
+		return ""
+	}
+	return label.goName() + ":\n" + g.indent("")
+}
+
+func (g *Generator) codegenBreak(keyword string, label *LoopLabel) string {
+	if label == nil {
+		return keyword
+	}
+	return keyword + " " + label.goName()
+}
+
+func (g *Generator) codegenWhile(node *WhileNode) string {
+	if _, isInfinite := node.condition.(*NoOpNode); isInfinite {
+		return fmt.Sprintf("%sfor %s", g.codegenLabel(node.label), g.codegenCompoundStatement(node.body.(*CompoundStatementNode)))
+	}
+
+	coerceType := node.conditionType
+	switch node.condition.(type) {
+	case *VarNode, *AssignNode:
+		coerceType = TypeBool{}
+	}
+	condition := g.codegenExpr(node.condition, coerceType)
+
+	// Pre-statements of the condition, eg. from fallible calls, have to run before every check
+	if len(g.preStatements) > 0 {
+		for _, preStatement := range g.preStatements {
+			g.addInitStatement(preStatement)
+		}
+		g.preStatements = nil
+		g.addInitStatement(fmt.Sprintf("if !(%s) { break }", condition))
+		return fmt.Sprintf("%sfor %s", g.codegenLabel(node.label), g.codegenCompoundStatement(node.body.(*CompoundStatementNode)))
+	}
+
+	return fmt.Sprintf("%sfor %s %s", g.codegenLabel(node.label), condition, g.codegenCompoundStatement(node.body.(*CompoundStatementNode)))
+}
+
 func (g *Generator) codegenForeach(node *ForeachNode) string {
+	label := g.codegenLabel(node.label)
 
 	// Foreach loop with range: `for 1..10 -> x`
 	switch n := node.iterator.(type) {
 	case *RangeNode:
-		return fmt.Sprintf("for %s := %s; %s <= %s; %s++ %s",
-			node.variable.token.str,
-			g.codegenExpr(n.from, TypeInt{}),
-			node.variable.token.str,
-			g.codegenExpr(n.to, TypeInt{}),
+		g.addPreludeFunction("rangeIter")
+		g.addInitStatement(fmt.Sprintf("_ = %s", node.variable.token.str))
+		return label + fmt.Sprintf("for %s := range ___rangeIter(%s) %s",
 			node.variable.token.str,
+			g.codegenRangeArguments(n),
 			g.codegenCompoundStatement(node.body.(*CompoundStatementNode)),
 		)
 	default:
+		// Foreach loop over map: `for m -> key, value`, in sorted key order
+		if _, isMap := node.iterType.(TypeMap); isMap {
+			g.addPreludeFunction("sortedItems")
+			controlVars := node.variable.token.str
+			g.addInitStatement(fmt.Sprintf("_ = %s", controlVars))
+			if node.hasIdx {
+				controlVars += ", " + node.idxVariable.token.str
+				g.addInitStatement(fmt.Sprintf("_ = %s", node.idxVariable.token.str))
+			}
+			return label + fmt.Sprintf("for %s := range ___sortedItems(%s) %s",
+				controlVars,
+				g.codegenExpr(node.iterator, NoCoercion{}),
+				g.codegenCompoundStatement(node.body.(*CompoundStatementNode)),
+			)
+		}
+
 		// Foreach loop with iterator: `for list -> x`
 		idxVarName := "_"
 		if node.hasIdx {
 			idxVarName = node.idxVariable.token.str
 		}
-		return fmt.Sprintf("for %s, %s := range %s %s",
+
+		// Strings are iterated by character, each as a string of its own
+		if node.iterType == (TypeString{}) {
+			g.tmpVarCount++
+			runeVar := fmt.Sprintf("___rune%d", g.tmpVarCount)
+			g.addInitStatement(fmt.Sprintf("%s := string(%s)", node.variable.token.str, runeVar))
+			g.addInitStatement(fmt.Sprintf("_ = %s", node.variable.token.str))
+			return label + fmt.Sprintf("for %s, %s := range []rune(%s) %s",
+				idxVarName,
+				runeVar,
+				g.codegenExpr(node.iterator, NoCoercion{}),
+				g.codegenCompoundStatement(node.body.(*CompoundStatementNode)),
+			)
+		}
+		if len(node.destructure) > 0 {
+			g.addDestructureInitStatements(node.variable.token.str, node.destructure)
+		}
+		return label + fmt.Sprintf("for %s, %s := range %s %s",
 			idxVarName,
 			g.codegenVar(&node.variable, NoCoercion{}),
 			g.codegenExpr(node.iterator, NoCoercion{}),
@@ -789,25 +1826,35 @@ func (g *Generator) codegenStatement(node Node) string {
 	case *CompoundStatementNode:
 		return g.codegenCompoundStatement(n)
 	case *FunctionNode:
-		return g.codegenFunction(n)
+		return g.codegenNestedFunction(n)
 	case *FunctionCallNode:
 		return g.codegenFunctionCall(n, NoCoercion{})
 	case *ReturnNode:
 		return g.codegenReturn(n)
 	case *FailNode:
 		return g.codegenFail(n)
+	case *YieldNode:
+		return g.codegenYield(n)
 	case *IfNode:
 		return g.codegenIf(n)
 	case *ForeachNode:
 		return g.codegenForeach(n)
+	case *WhileNode:
+		return g.codegenWhile(n)
 	case *ContinueNode:
-		return "continue"
+		return g.codegenBreak("continue", n.label)
 	case *BreakNode:
-		return "break"
+		return g.codegenBreak("break", n.label)
+	case *CompoundAssignNode:
+		return g.codegenCompoundAssign(n)
+	case *DestructureNode:
+		return g.codegenDestructure(n)
 	case *IncNode:
 		return g.codegenInc(n)
 	case *DecNode:
 		return g.codegenDec(n)
+	case *MatchNode:
+		return g.codegenMatch(n)
 	default:
 		fmt.Printf("CODEGEN TODO: Unknown node in statement: %T\n", node)
 		panic("")
@@ -819,7 +1866,7 @@ func (g *Generator) codegenExpr(node Node, coercion Type) string {
 	case *NoOpNode:
 		return ""
 	case *UnaryOpNode:
-		return g.codegenUnaryOp(n)
+		return g.codegenUnaryOp(n, coercion)
 	case *BinOpNode:
 		return g.codegenBinOp(n, coercion)
 	case *NumNode:
@@ -828,8 +1875,12 @@ func (g *Generator) codegenExpr(node Node, coercion Type) string {
 		return g.codegenBool(n)
 	case *StringLiteralNode:
 		return g.codegenStringLiteral(n, coercion)
+	case *InterpolatedStringNode:
+		return g.codegenInterpolatedString(n, coercion)
 	case *VarNode:
 		return g.codegenVar(n, coercion)
+	case *LambdaNode:
+		return g.codegenLambda(n)
 	case *IndexedVarNode:
 		return g.codegenIndexedVar(n, coercion)
 	case *FunctionCallNode:
@@ -838,19 +1889,256 @@ func (g *Generator) codegenExpr(node Node, coercion Type) string {
 		return g.codegenSliceLiteral(n, coercion)
 	case *SetLiteralNode:
 		return g.codegenSetLiteral(n, coercion)
+	case *MapLiteralNode:
+		return g.codegenMapLiteral(n, coercion)
+	case *TupleLiteralNode:
+		return g.codegenTupleLiteral(n, coercion)
+	case *FieldAccessNode:
+		return g.codegenFieldAccess(n, coercion)
 	case *RangeNode:
+		g.addPreludeFunction("rangeIter")
 		g.addPreludeFunction("createRange")
-		return fmt.Sprintf("___createRange(%s, %s)", g.codegenExpr(n.from, TypeInt{}), g.codegenExpr(n.to, TypeInt{}))
+		return fmt.Sprintf("___createRange(%s)", g.codegenRangeArguments(n))
 	case *AssignNode:
 		return g.codegenAssignExpr(n, coercion)
+	case *MatchNode:
+		return g.codegenMatchExpr(n, coercion)
+	case *IfExprNode:
+		return g.codegenIfExpr(n, coercion)
 	default:
 		fmt.Printf("CODEGEN TODO: Unknown node in expression: %T\n", node)
 		panic("")
 	}
 }
 
+func (g *Generator) codegenRecord(node *RecordNode) string {
+	var fields []string
+	for _, field := range node.fields.(*ParameterListNode).parameters {
+		fields = append(fields, "    "+g.codegenParameter(&field))
+	}
+	return fmt.Sprintf("type %s struct {\n%s\n}", node.token.str, strings.Join(fields, "\n"))
+}
+
+// Enums become an interface, implemented by one struct per variant holding its payload.
+// Variants print like they are written, eg. `Circle(2.5)`.
+func (g *Generator) codegenEnum(node *EnumNode) string {
+	name := node.token.str
+	declarations := []string{fmt.Sprintf("type %s interface {\n    is%s()\n}", name, name)}
+	for _, variant := range node.variants {
+		typeName := variantTypeName(name + "." + variant.name)
+		var fields []string
+		var formats []string
+		var values []string
+		for _, field := range variant.fields.parameters {
+			fields = append(fields, "    "+g.codegenParameter(&field))
+			formats = append(formats, "%v")
+			values = append(values, "v."+field.name)
+		}
+
+		str := strconv.Quote(variant.name)
+		if len(fields) > 0 {
+			g.addImport("fmt")
+			str = fmt.Sprintf("fmt.Sprintf(%s, %s)", strconv.Quote(variant.name+"("+strings.Join(formats, ", ")+")"), strings.Join(values, ", "))
+			declarations = append(declarations, fmt.Sprintf("type %s struct {\n%s\n}", typeName, strings.Join(fields, "\n")))
+		} else {
+			declarations = append(declarations, fmt.Sprintf("type %s struct{}", typeName))
+		}
+		declarations = append(declarations, fmt.Sprintf("func (%s) is%s() {}", typeName, name))
+		declarations = append(declarations, fmt.Sprintf("func (v %s) String() string { return %s }", typeName, str))
+	}
+	return strings.Join(declarations, "\n\n")
+}
+
+// The Go type of an enum variant, eg. `Shape_Circle` for `Shape.Circle`
+func variantTypeName(name string) string {
+	return strings.Replace(name, ".", "_", 1)
+}
+
+// Matches become if-else chains rather than go switches, so that `break` and `continue`
+// in the arms still refer to the enclosing loop. The subject is evaluated once, in a
+// pre-statement. Returns the start of the chain, each arm is generated by `arm`.
+func (g *Generator) codegenMatchChain(node *MatchNode, arm func(MatchArm) string) string {
+	g.tmpVarCount++
+	subjectVar := fmt.Sprintf("___match%d", g.tmpVarCount)
+	g.addPreStatement(fmt.Sprintf("%s := %s", subjectVar, g.codegenExpr(node.subject, NoCoercion{})))
+	g.addPreStatement(fmt.Sprintf("_ = %s", subjectVar))
+
+	var chain string
+	for i, matchArm := range node.arms {
+		pattern := matchArm.pattern
+		var condition string
+		switch pattern.kind {
+		case WildcardPattern:
+			if i == 0 {
+				return arm(matchArm)
+			}
+			return chain + " else " + arm(matchArm)
+		case VariantPattern:
+			variantVar := "_"
+			for j, binding := range pattern.bindings {
+				if binding == "_" {
+					continue
+				}
+				variantVar = "___variant"
+				symbol, _ := g.scope.lookupSymbol(pattern.enum + "." + pattern.variant)
+				g.addInitStatement(fmt.Sprintf("%s := ___variant.%s", binding, symbol.paramsNode.parameters[j].name))
+				g.addInitStatement(fmt.Sprintf("_ = %s", binding))
+			}
+			condition = fmt.Sprintf("%s, ok := %s.(%s); ok", variantVar, subjectVar, variantTypeName(pattern.enum+"."+pattern.variant))
+		case LiteralPattern:
+			condition = fmt.Sprintf("%s == %s", subjectVar, g.codegenExpr(pattern.value, node.subjectType))
+		case RangePattern:
+			operator := "<"
+			if pattern.inclusive {
+				operator = "<="
+			}
+			condition = fmt.Sprintf("%s >= %s && %s %s %s", subjectVar, g.codegenExpr(pattern.value, node.subjectType), subjectVar, operator, g.codegenExpr(pattern.to, node.subjectType))
+		case RegexPattern:
+			g.addPreludeFunction("regexMatch")
+			condition = fmt.Sprintf("___regexMatch(%s, %s)", subjectVar, g.codegenExpr(pattern.value, TypeString{}))
+		}
+
+		if i > 0 {
+			chain += " else "
+		}
+		chain += fmt.Sprintf("if %s %s", condition, arm(matchArm))
+	}
+	return chain
+}
+
+func (g *Generator) codegenMatch(node *MatchNode) string {
+	return g.codegenMatchChain(node, func(arm MatchArm) string {
+		return g.codegenCompoundStatement(arm.body.(*CompoundStatementNode))
+	})
+}
+
+// Match expressions assign the value of the matching arm to a result variable, declared
+// in a pre-statement together with the chain. Pre-statements of an arm stay inside its block.
+func (g *Generator) codegenMatchExpr(node *MatchNode, coercion Type) string {
+	g.tmpVarCount++
+	resultVar := fmt.Sprintf("___matchResult%d", g.tmpVarCount)
+	g.addPreStatement(fmt.Sprintf("var %s %s", resultVar, g.codegenType(node.typ)))
+
+	chain := g.codegenMatchChain(node, func(arm MatchArm) string {
+		preStatements := g.preStatements
+		g.preStatements = nil
+		prevScope := g.scope
+		g.scope = arm.scope
+		g.indentLevel++
+
+		var statements []string
+		for _, initStatement := range g.initStatements {
+			statements = append(statements, g.indent(initStatement))
+		}
+		g.initStatements = nil
+		value := g.codegenExpr(arm.body, node.typ)
+		for _, preStatement := range g.preStatements {
+			statements = append(statements, g.indent(preStatement))
+		}
+		statements = append(statements, g.indent(fmt.Sprintf("%s = %s", resultVar, value)))
+
+		g.indentLevel--
+		g.scope = prevScope
+		g.preStatements = preStatements
+		return fmt.Sprintf("{\n%s\n%s", strings.Join(statements, "\n"), g.indent("}"))
+	})
+	g.addPreStatement(chain)
+	return g.coerce(resultVar, node.typ, coercion, CoercionModeDefault, node)
+}
+
+// If expressions assign the value of the taken branch to a result variable. The condition's
+// pre-statements run before the if, those of a branch only when it is taken, and those of an
+// `else if` condition only when the conditions before it are false.
+func (g *Generator) codegenIfExpr(node *IfExprNode, coercion Type) string {
+	g.tmpVarCount++
+	resultVar := fmt.Sprintf("___ifResult%d", g.tmpVarCount)
+	g.addPreStatement(fmt.Sprintf("var %s %s", resultVar, g.codegenType(node.typ)))
+	g.addPreStatement(g.codegenIfExprChain(node, resultVar))
+	return g.coerce(resultVar, node.typ, coercion, CoercionModeDefault, node)
+}
+
+func (g *Generator) codegenIfExprChain(node *IfExprNode, resultVar string) string {
+	coerceType := node.compType
+	switch node.comp.(type) {
+	case *VarNode, *AssignNode:
+		coerceType = TypeBool{}
+	}
+	comparison := g.codegenExpr(node.comp, coerceType)
+	body := g.codegenBranch(node.body, node.bodyScope, resultVar, node.typ)
+	elseBody := g.codegenBranch(node.elseBody, node.elseScope, resultVar, node.typ)
+	return fmt.Sprintf("if %s %s else %s", comparison, body, elseBody)
+}
+
+// Generates the block of a branch assigning its value to the result variable, with the
+// pre-statements of the value kept inside the block
+func (g *Generator) codegenBranch(node Node, scope *Scope, resultVar string, typ Type) string {
+	preStatements := g.preStatements
+	g.preStatements = nil
+	prevScope := g.scope
+	if scope != nil {
+		g.scope = scope
+	}
+	g.indentLevel++
+
+	var assignment string
+	if elseIf, isElseIf := node.(*IfExprNode); isElseIf {
+		assignment = g.codegenIfExprChain(elseIf, resultVar)
+	} else {
+		assignment = fmt.Sprintf("%s = %s", resultVar, g.codegenExpr(node, typ))
+	}
+	var statements []string
+	for _, preStatement := range g.preStatements {
+		statements = append(statements, g.indent(preStatement))
+	}
+	statements = append(statements, g.indent(assignment))
+
+	g.indentLevel--
+	g.scope = prevScope
+	g.preStatements = preStatements
+	return fmt.Sprintf("{\n%s\n%s", strings.Join(statements, "\n"), g.indent("}"))
+}
+
+// Constants become go constants, while module-level variables are declared at package
+// level and assigned in an init function, which allows them to generate pre-statements.
+func (g *Generator) codegenGlobals(node *ProgramNode) string {
+	var declarations []string
+	var assignments []Node
+	for _, global := range node.globals {
+		switch n := global.(type) {
+		case *ConstNode:
+			declarations = append(declarations, fmt.Sprintf("const %s %s = %s", n.token.str, g.codegenType(n.typ), g.codegenExpr(n.expr, NoCoercion{})))
+		case *AssignNode:
+			name := n.left.(*VarNode).token.str
+			symbol, _ := node.scope.lookupSymbol(name)
+			if n.declaration {
+				declarations = append(declarations, fmt.Sprintf("var %s %s", name, g.codegenType(symbol.typ)))
+			}
+			assignment := *n
+			assignment.declaration = false
+			assignments = append(assignments, &assignment)
+		}
+	}
+
+	if len(assignments) > 0 {
+		initScope := newScope(node.scope, nil, TypeVoid{}, false)
+		initBody := g.codegenCompoundStatement(&CompoundStatementNode{children: assignments, scope: initScope})
+		declarations = append(declarations, "func init() "+initBody)
+	}
+	return strings.Join(declarations, "\n")
+}
+
 func (g *Generator) codegenProgram(node Node) string {
+	g.fileNames = node.(*ProgramNode).fileNames
 	var functionStrs []string
+	for _, record := range node.(*ProgramNode).records {
+		functionStrs = append(functionStrs, g.codegenRecord(record.(*RecordNode)))
+	}
+	for _, enum := range node.(*ProgramNode).enums {
+		functionStrs = append(functionStrs, g.codegenEnum(enum.(*EnumNode)))
+	}
+	if len(node.(*ProgramNode).globals) > 0 {
+		functionStrs = append(functionStrs, g.codegenGlobals(node.(*ProgramNode)))
+	}
 	for _, function := range node.(*ProgramNode).functions {
 		functionStrs = append(functionStrs, g.codegenFunction(function.(*FunctionNode)))
 	}
@@ -873,11 +2161,11 @@ func (g *Generator) codegenProgram(node Node) string {
 }
 
 func GenerateCode(root Node) (string, error) {
-	generator := Generator{0, nil, []string{}, make(map[string]bool), make(map[string]bool), []string{}, []string{}, []string{}, []string{}, 0, false, 0}
+	generator := Generator{0, nil, []string{}, make(map[string]bool), make(map[string]bool), []string{}, []string{}, []string{}, []string{}, 0, false, 0, nil}
 	code := generator.codegenProgram(root)
 
 	if len(generator.errors) > 0 {
-		return "", fmt.Errorf(strings.Join(generator.errors, "\n"))
+		return "", errors.New(strings.Join(generator.errors, "\n"))
 	}
 
 	return code, nil
@@ -7,6 +7,7 @@ const (
 	Integer
 	Float
 	Comma
+	Colon
 	Period
 	OpenCurly
 	CloseCurly
@@ -23,17 +24,34 @@ const (
 	Not
 	NotEqual
 	Assign
+	PlusAssign
+	MinusAssign
+	MultAssign
+	DivAssign
+	ModuloAssign
 	Minus
 	MinusMinus
 	Plus
 	PlusPlus
 	Mult
 	Div
+	Modulo
+	Power
+	IntDiv
+	BitAnd
+	BitOr
+	BitXor
+	ShiftLeft
+	ShiftRight
 	Comment
 	RightArrow
+	FatArrow
 	Whitespace
 	StringLiteral
+	InterpolationStart
+	InterpolationEnd
 	Range
+	RangeInclusive
 	QuestionMark
 	LogicAnd
 	LogicOr
@@ -49,6 +67,7 @@ func (s TokenKind) String() string {
 	case Integer: return "Integer"
 	case Float: return "Float"
 	case Comma: return "Comma"
+	case Colon: return "Colon"
 	case Period: return "Period"
 	case OpenCurly: return "OpenCurly"
 	case CloseCurly: return "CloseCurly"
@@ -65,17 +84,34 @@ func (s TokenKind) String() string {
 	case Not: return "Not"
 	case NotEqual: return "NotEqual"
 	case Assign: return "Assign"
+	case PlusAssign: return "PlusAssign"
+	case MinusAssign: return "MinusAssign"
+	case MultAssign: return "MultAssign"
+	case DivAssign: return "DivAssign"
+	case ModuloAssign: return "ModuloAssign"
 	case Minus: return "Minus"
 	case MinusMinus: return "MinusMinus"
 	case Plus: return "Plus"
 	case PlusPlus: return "PlusPlus"
 	case Mult: return "Mult"
 	case Div: return "Div"
+	case Modulo: return "Modulo"
+	case Power: return "Power"
+	case IntDiv: return "IntDiv"
+	case BitAnd: return "BitAnd"
+	case BitOr: return "BitOr"
+	case BitXor: return "BitXor"
+	case ShiftLeft: return "ShiftLeft"
+	case ShiftRight: return "ShiftRight"
 	case Comment: return "Comment"
 	case RightArrow: return "RightArrow"
+	case FatArrow: return "FatArrow"
 	case Whitespace: return "Whitespace"
 	case StringLiteral: return "StringLiteral"
+	case InterpolationStart: return "InterpolationStart"
+	case InterpolationEnd: return "InterpolationEnd"
 	case Range: return "Range"
+	case RangeInclusive: return "RangeInclusive"
 	case QuestionMark: return "QuestionMark"
 	case LogicAnd: return "LogicAnd"
 	case LogicOr: return "LogicOr"
@@ -87,6 +123,29 @@ func (s TokenKind) String() string {
 }
 
 
+type PatternKind int
+const (
+	WildcardPattern PatternKind = iota
+	VariantPattern
+	LiteralPattern
+	RangePattern
+	RegexPattern
+)
+
+
+func (s PatternKind) String() string {
+	switch s {
+	case WildcardPattern: return "WildcardPattern"
+	case VariantPattern: return "VariantPattern"
+	case LiteralPattern: return "LiteralPattern"
+	case RangePattern: return "RangePattern"
+	case RegexPattern: return "RegexPattern"
+
+	default: return "???"
+	}
+}
+
+
 type CoercionMode int
 const (
 	CoercionModeDefault CoercionMode = iota
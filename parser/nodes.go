@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -88,13 +89,33 @@ type StringLiteralNode struct {
 
 func (n *StringLiteralNode) Print(level int) {
 	indentation := strings.Repeat(" ", level*4)
-	fmt.Println(indentation + "\"" + n.token.str + "\"")
+	fmt.Println(indentation + strconv.Quote(n.token.str))
 }
 
 func (n *StringLiteralNode) Precedence() int {
 	return 0
 }
 
+// Interpolated string, parts alternate between string literals and expressions
+type InterpolatedStringNode struct {
+	CommonNode
+	token Token
+	parts []Node
+	types []Type
+}
+
+func (n *InterpolatedStringNode) Print(level int) {
+	indentation := strings.Repeat(" ", level*4)
+	fmt.Println(indentation + "InterpolatedString")
+	for _, part := range n.parts {
+		part.Print(level + 1)
+	}
+}
+
+func (n *InterpolatedStringNode) Precedence() int {
+	return 0
+}
+
 // Variable node
 type VarNode struct {
 	CommonNode
@@ -113,13 +134,18 @@ func (n *VarNode) Precedence() int {
 // Indexed variable node
 type IndexedVarNode struct {
 	CommonNode
-	token Token
-	index Node
+	token     Token
+	index     Node
+	container *IndexedVarNode // The indexed element of a nested container, eg. grid[1] in grid[1][2]
 }
 
 func (n *IndexedVarNode) Print(level int) {
 	indentation := strings.Repeat(" ", level*4)
 	fmt.Println(indentation + "Indexed Variable: " + n.token.str)
+	if n.container != nil {
+		fmt.Println(indentation + "Container:")
+		n.container.Print(level + 1)
+	}
 	fmt.Println(indentation + "Index:")
 	n.index.Print(level + 1)
 }
@@ -131,9 +157,11 @@ func (n *IndexedVarNode) Precedence() int {
 // Binary operator node
 type BinOpNode struct {
 	CommonNode
-	left  Node
-	token Token
-	right Node
+	left        Node
+	token       Token
+	right       Node
+	typ         Type
+	operandType Type
 }
 
 func (n *BinOpNode) Print(level int) {
@@ -151,21 +179,38 @@ func (n *BinOpNode) Precedence() int {
 		return 2
 	case Greater, Less, GreaterEqual, LessEqual:
 		return 3
-	case Plus, Minus:
+	case Plus, Minus, BitOr, BitXor:
 		return 4
-	case Mult, Div:
+	case Mult, Div, Modulo, IntDiv, BitAnd, ShiftLeft, ShiftRight:
 		return 5
+	case Power:
+		return 6
 
 	default:
 		panic("Precedence not implemented for binary operator")
 	}
 }
 
+// Comparisons and logical operators produce a bool, whatever the type of the operands
+func (n *BinOpNode) isComparison() bool {
+	return n.Precedence() <= 3
+}
+
+// Operators that are only defined for numbers and have their own codegen
+func (n *BinOpNode) isArithmetic() bool {
+	switch n.token.kind {
+	case Modulo, Power, IntDiv, BitAnd, BitOr, BitXor, ShiftLeft, ShiftRight:
+		return true
+	}
+	return false
+}
+
 // Unary operator node
 type UnaryOpNode struct {
 	CommonNode
 	token Token
 	expr  Node
+	typ   Type
 }
 
 func (n *UnaryOpNode) Print(level int) {
@@ -176,7 +221,7 @@ func (n *UnaryOpNode) Print(level int) {
 
 func (n *UnaryOpNode) Precedence() int {
 	switch n.token.kind {
-	case Not:
+	case Not, Minus:
 		return 6
 	default:
 		panic("Precedence not implemented for unary operator")
@@ -243,6 +288,9 @@ type FunctionNode struct {
 	body       Node
 	returnType Type
 	fallible   bool
+	typeParams []string
+	// Shared with the function's symbol, filled in while parsing and type checking its body
+	typeParamConstraints map[string]TypeConstraint
 }
 
 func (n *FunctionNode) Print(level int) {
@@ -256,6 +304,132 @@ func (n *FunctionNode) Precedence() int {
 	return 0
 }
 
+// Constant declaration
+type ConstNode struct {
+	CommonNode
+	token Token
+	expr  Node
+	typ   Type
+}
+
+func (n *ConstNode) Print(level int) {
+	indentation := strings.Repeat(" ", level*4)
+	fmt.Println(indentation+"Constant", n.token.str, n.typ)
+	n.expr.Print(level + 1)
+}
+
+func (n *ConstNode) Precedence() int {
+	return 0
+}
+
+// Record declaration
+type RecordNode struct {
+	CommonNode
+	token  Token
+	fields Node
+}
+
+func (n *RecordNode) Print(level int) {
+	indentation := strings.Repeat(" ", level*4)
+	fmt.Println(indentation+"Record", n.token.str)
+	n.fields.Print(level + 1)
+}
+
+func (n *RecordNode) Precedence() int {
+	return 0
+}
+
+// Enum declaration, eg. `enum Shape { Circle(r float) Square(side float) Empty }`
+type EnumNode struct {
+	CommonNode
+	token    Token
+	variants []EnumVariant
+}
+
+// Variant of an enum, with its payload fields if it has any
+type EnumVariant struct {
+	token  Token
+	name   string
+	fields *ParameterListNode
+}
+
+func (n *EnumNode) Print(level int) {
+	indentation := strings.Repeat(" ", level*4)
+	fmt.Println(indentation+"Enum", n.token.str)
+	for _, variant := range n.variants {
+		fmt.Println(indentation + "    Variant " + variant.name)
+		variant.fields.Print(level + 2)
+	}
+}
+
+func (n *EnumNode) Precedence() int {
+	return 0
+}
+
+// Match statement or expression, eg. `match shape { Shape.Circle(r) => r * r  _ => 0 }`
+type MatchNode struct {
+	CommonNode
+	token       Token
+	subject     Node
+	subjectType Type
+	arms        []MatchArm
+	expression  bool
+	typ         Type
+}
+
+// An arm of a match. When the match is used as a value, the body is an expression
+// evaluated in the scope of the arm, otherwise it is a compound statement.
+type MatchArm struct {
+	pattern MatchPattern
+	body    Node
+	scope   *Scope
+}
+
+// Patterns of match arms: `_`, enum variants with their payload bound to names,
+// literals, ranges such as `1..=9`, and regexes such as `re"^#"`
+type MatchPattern struct {
+	token     Token
+	kind      PatternKind
+	enum      string
+	variant   string
+	bindings  []string
+	value     Node
+	to        Node
+	inclusive bool
+}
+
+func (n *MatchNode) Print(level int) {
+	indentation := strings.Repeat(" ", level*4)
+	fmt.Println(indentation+"Match, expression?", n.expression)
+	n.subject.Print(level + 1)
+	for _, arm := range n.arms {
+		fmt.Println(indentation+"    Arm", arm.pattern.kind, arm.pattern.token.str)
+		arm.body.Print(level + 2)
+	}
+}
+
+func (n *MatchNode) Precedence() int {
+	return 1000
+}
+
+// Record field access, eg. `row.name`
+type FieldAccessNode struct {
+	CommonNode
+	token Token
+	expr  Node
+	typ   Type
+}
+
+func (n *FieldAccessNode) Print(level int) {
+	indentation := strings.Repeat(" ", level*4)
+	fmt.Println(indentation+"Field access:", n.token.str, "type:", n.typ)
+	n.expr.Print(level + 1)
+}
+
+func (n *FieldAccessNode) Precedence() int {
+	return 7
+}
+
 // Argument
 type ArgumentNode struct {
 	CommonNode
@@ -286,12 +460,16 @@ type FunctionCallNode struct {
 	isBuiltin          bool
 	resolvedArgs       map[string]ArgumentNode
 	resolvedReturnType Type
+	typeArgs           map[string]Type
 	errorHandled       bool
 	generatorBody      Node
 	generatorVar       VarNode
 	generatorHasIdx    bool
 	generatorIdxVar    VarNode
+	generatorDestructure []VarNode
+	generatorLabel     *LoopLabel
 	errorBody          Node
+	streamed           bool // Generator consumed by a higher-order builtin, eg. `read(path)?.filter(f)`
 }
 
 func (n *FunctionCallNode) Print(level int) {
@@ -369,14 +547,30 @@ func (n *FunctionCallNode) matchArgsToParams(parameters []ParameterNode) error {
 // Program node
 type ProgramNode struct {
 	Node
+	records   []Node
+	enums     []Node
+	globals   []Node
 	functions []Node
+	scope     *Scope
 	imports   map[string]bool
 	preludes  map[string]bool
+	fileNames []string
+	// Files declared `strict`, where values are not implicitly converted between types
+	strictFiles map[int]bool
 }
 
 func (n *ProgramNode) Print(level int) {
 	indentation := strings.Repeat(" ", level*4)
 	fmt.Println(indentation + "Program")
+	for _, record := range n.records {
+		record.Print(level + 1)
+	}
+	for _, enum := range n.enums {
+		enum.Print(level + 1)
+	}
+	for _, global := range n.globals {
+		global.Print(level + 1)
+	}
 	for _, arg := range n.functions {
 		arg.Print(level + 1)
 	}
@@ -474,6 +668,7 @@ func (n *ReturnNode) Precedence() int {
 type ContinueNode struct {
 	CommonNode
 	token Token
+	label *LoopLabel
 }
 
 func (n *ContinueNode) Print(level int) {
@@ -489,6 +684,7 @@ func (n *ContinueNode) Precedence() int {
 type BreakNode struct {
 	CommonNode
 	token Token
+	label *LoopLabel
 }
 
 func (n *BreakNode) Print(level int) {
@@ -523,6 +719,28 @@ func (n *FailNode) Precedence() int {
 	return 100
 }
 
+// Yield node
+type YieldNode struct {
+	CommonNode
+	token Token
+	expr  Node
+	typ   Type
+}
+
+func (n *YieldNode) Print(level int) {
+	indentation := strings.Repeat(" ", level*4)
+	fmt.Println(indentation + "Yield")
+	n.expr.Print(level + 1)
+}
+
+func (n *YieldNode) setType(typ Type) {
+	n.typ = typ
+}
+
+func (n *YieldNode) Precedence() int {
+	return 100
+}
+
 // If node
 type IfNode struct {
 	CommonNode
@@ -553,6 +771,33 @@ func (n *IfNode) Precedence() int {
 	return 1000
 }
 
+// If used as a value, eg. `if n > 10 { "big" } else { "small" }`. Each branch is an
+// expression in its own scope, an `else if` is a nested IfExprNode without a scope.
+type IfExprNode struct {
+	CommonNode
+	token     Token
+	comp      Node
+	compType  Type
+	body      Node
+	bodyScope *Scope
+	elseBody  Node
+	elseScope *Scope
+	typ       Type
+}
+
+func (n *IfExprNode) Print(level int) {
+	indentation := strings.Repeat(" ", level*4)
+	fmt.Println(indentation + "IfExpr")
+	n.comp.Print(level + 1)
+	n.body.Print(level + 1)
+	fmt.Println(indentation + "Else")
+	n.elseBody.Print(level + 1)
+}
+
+func (n *IfExprNode) Precedence() int {
+	return 1000
+}
+
 // Foreach node
 type ForeachNode struct {
 	CommonNode
@@ -562,6 +807,9 @@ type ForeachNode struct {
 	idxVariable VarNode
 	hasIdx      bool
 	body        Node
+	iterType    Type
+	destructure []VarNode
+	label       *LoopLabel
 }
 
 func (n *ForeachNode) Print(level int) {
@@ -582,6 +830,41 @@ func (n *ForeachNode) Precedence() int {
 	return 1000
 }
 
+// Label of a loop, eg. `outer:` in `outer: for rows -> row`. Go rejects unused labels,
+// so only labels targeted by a `break` or `continue` are generated.
+type LoopLabel struct {
+	name string
+	id   int
+	used bool
+}
+
+// Go labels are scoped to the whole function, the id keeps sibling loops with the same label apart
+func (l *LoopLabel) goName() string {
+	return fmt.Sprintf("___%s_%d", l.name, l.id)
+}
+
+// While node, for condition-driven loops: `while cond { }`, `for cond { }` and `loop { }`
+type WhileNode struct {
+	CommonNode
+	token         Token
+	condition     Node
+	conditionType Type
+	body          Node
+	label         *LoopLabel
+}
+
+func (n *WhileNode) Print(level int) {
+	indentation := strings.Repeat(" ", level*4)
+	fmt.Println(indentation + "While, condition:")
+	n.condition.Print(level + 1)
+	fmt.Println(indentation + "While, body:")
+	n.body.Print(level + 1)
+}
+
+func (n *WhileNode) Precedence() int {
+	return 1000
+}
+
 // SLice literal node
 type SliceLiteralNode struct {
 	CommonNode
@@ -624,6 +907,122 @@ func (n *SetLiteralNode) Precedence() int {
 	return 1000
 }
 
+// Map literal node
+type MapLiteralNode struct {
+	CommonNode
+	token     Token
+	keys      []Node
+	values    []Node
+	keyType   Type
+	valueType Type
+}
+
+func (n *MapLiteralNode) Print(level int) {
+	indentation := strings.Repeat(" ", level*4)
+	fmt.Println(indentation+"Map literal,", n.keyType, n.valueType)
+	for i := range n.keys {
+		fmt.Println(indentation+"    "+"Key", i)
+		n.keys[i].Print(level + 1)
+		fmt.Println(indentation+"    "+"Value", i)
+		n.values[i].Print(level + 1)
+	}
+}
+
+func (n *MapLiteralNode) Precedence() int {
+	return 1000
+}
+
+// Compound assignment, eg. `total += x`
+type CompoundAssignNode struct {
+	CommonNode
+	token      Token
+	left       Node
+	right      Node
+	targetType Type
+	valueType  Type
+}
+
+func (n *CompoundAssignNode) Print(level int) {
+	indentation := strings.Repeat(" ", level*4)
+	fmt.Println(indentation+"CompoundAssign", n.token.str)
+	n.left.Print(level + 1)
+	n.right.Print(level + 1)
+}
+
+func (n *CompoundAssignNode) Precedence() int {
+	return 1000
+}
+
+// Tuple literal, eg. `return count, total` or `(1, "a")`
+type TupleLiteralNode struct {
+	CommonNode
+	token    Token
+	elements []Node
+	typ      TypeTuple
+}
+
+func (n *TupleLiteralNode) Print(level int) {
+	indentation := strings.Repeat(" ", level*4)
+	fmt.Println(indentation + "Tuple")
+	for _, element := range n.elements {
+		element.Print(level + 1)
+	}
+}
+
+func (n *TupleLiteralNode) Precedence() int {
+	return 1000
+}
+
+// Anonymous function, eg. `x => x * 2`. The body is a single expression.
+type LambdaNode struct {
+	CommonNode
+	token      Token
+	parameters []ParameterNode
+	body       Node
+	scope      *Scope
+	expected   Type // The function type expected where the lambda is used, if known
+	bodyType   Type
+	typ        TypeFunction
+}
+
+func (n *LambdaNode) Print(level int) {
+	indentation := strings.Repeat(" ", level*4)
+	var params []string
+	for _, param := range n.parameters {
+		params = append(params, param.name+" "+param.typ.String())
+	}
+	fmt.Println(indentation + "Lambda (" + strings.Join(params, ", ") + ")")
+	n.body.Print(level + 1)
+}
+
+func (n *LambdaNode) Precedence() int {
+	return 1000
+}
+
+// Destructuring assignment, eg. `count, _ = stats(values)`
+type DestructureNode struct {
+	CommonNode
+	token        Token
+	targets      []VarNode
+	declarations []bool
+	right        Node
+	tupleType    TypeTuple
+}
+
+func (n *DestructureNode) Print(level int) {
+	indentation := strings.Repeat(" ", level*4)
+	var names []string
+	for _, target := range n.targets {
+		names = append(names, target.token.str)
+	}
+	fmt.Println(indentation + "Destructure " + strings.Join(names, ", "))
+	n.right.Print(level + 1)
+}
+
+func (n *DestructureNode) Precedence() int {
+	return 1000
+}
+
 // Increment node
 type IncNode struct {
 	CommonNode
@@ -658,21 +1057,26 @@ func (n *DecNode) Precedence() int {
 }
 
 // Range node
+// Ranges exclude their end value, unless written as `from..=to`. The step is a NoOpNode
+// unless given with `by`, and a range counts down when its start is larger than its end.
 type RangeNode struct {
 	Node
-	token Token
-	from  Node
-	to    Node
-	step  int
+	token     Token
+	from      Node
+	to        Node
+	step      Node
+	inclusive bool
 }
 
 func (n *RangeNode) Print(level int) {
 	indentation := strings.Repeat(" ", level*4)
-	fmt.Println(indentation+"Range, step:", n.step)
+	fmt.Println(indentation+"Range, inclusive:", n.inclusive)
 	fmt.Println(indentation + "    " + "From:")
 	n.from.Print(level + 1)
 	fmt.Println(indentation + "    " + "To:")
 	n.to.Print(level + 1)
+	fmt.Println(indentation + "    " + "Step:")
+	n.step.Print(level + 1)
 }
 
 func (n *RangeNode) Precedence() int {
@@ -2,6 +2,10 @@ package parser
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 )
 
 type Symbol struct {
@@ -11,6 +15,10 @@ type Symbol struct {
 	fallible   bool
 	category   SymbolCategory
 	paramsNode *ParameterListNode
+	typeParams []string
+	variants   []string
+	// How the type parameters of a generic function are constrained by their use
+	typeParamConstraints map[string]TypeConstraint
 }
 
 func (v *Symbol) setUsed() {
@@ -21,6 +29,32 @@ func (v *Symbol) getType(typ Type) Type {
 	return v.typ
 }
 
+// Returns the parameters and return type of anything that can be called: functions,
+// records, enum variants and variables holding functions
+func (v *Symbol) signature() ([]ParameterNode, Type, bool) {
+	switch v.category {
+	case FunctionSymbol, RecordSymbol, VariantSymbol:
+		return v.paramsNode.parameters, v.typ, true
+	}
+	if functionType, isFunction := v.typ.(TypeFunction); isFunction {
+		var parameters []ParameterNode
+		for i, typ := range functionType.ParamTypes() {
+			parameters = append(parameters, ParameterNode{name: fmt.Sprintf("___arg%d", i), typ: typ})
+		}
+		return parameters, functionType.ReturnType, true
+	}
+	return nil, TypeUndetermined{}, false
+}
+
+// The type of a named function used as a value, eg. `apply(double, 3)`
+func (v *Symbol) functionType() TypeFunction {
+	var params []Type
+	for _, param := range v.paramsNode.parameters {
+		params = append(params, param.typ)
+	}
+	return newFunctionType(params, v.typ)
+}
+
 type Scope struct {
 	parent     *Scope
 	symbols    map[string]Symbol
@@ -33,6 +67,11 @@ type SymbolCategory int
 const (
 	VariableSymbol SymbolCategory = iota
 	FunctionSymbol
+	RecordSymbol
+	ConstantSymbol
+	EnumSymbol
+	// Variants are declared as `Enum.Variant`, their parameters are the payload fields
+	VariantSymbol
 )
 
 func newScope(parent *Scope, parameters []ParameterNode, returnType Type, fallible bool) *Scope {
@@ -42,7 +81,7 @@ func newScope(parent *Scope, parameters []ParameterNode, returnType Type, fallib
 	// Add function parameters to the scopes list of declared symbols
 	if parameters != nil {
 		for _, param := range parameters {
-			symbols[param.name] = Symbol{param.typ, param.name, false, false, VariableSymbol, &ParameterListNode{}}
+			symbols[param.name] = Symbol{param.typ, param.name, false, false, VariableSymbol, &ParameterListNode{}, nil, nil, nil}
 		}
 	}
 
@@ -72,7 +111,7 @@ func (s *Scope) createSymbol(name string, category SymbolCategory, typ Type, par
 	if _, exists := s.symbols[name]; exists {
 		return false
 	}
-	s.symbols[name] = Symbol{typ, name, false, fallible, category, paramsNode}
+	s.symbols[name] = Symbol{typ, name, false, fallible, category, paramsNode, nil, nil, nil}
 	return true
 }
 
@@ -84,17 +123,31 @@ func (s *Scope) closestReturningScope() *Scope {
 	return rs
 }
 
+// Source files of a program, shared by the parsers of all imported files
+type Modules struct {
+	fileNames []string
+	loaded    map[string]bool // Absolute paths of files that have been parsed
+	loading   []int           // Files currently being parsed, for detecting import cycles
+	functions map[string]bool // Top-level functions, which can be used as values before their declaration
+	enums     map[string]bool // Enums, which can be used as types before their declaration
+}
+
 type Parser struct {
 	tokens       []Token
 	tokenIdx     int
 	blockDepth   int
 	currentScope *Scope
 	imports      map[string]bool
-	fileNames    []string
+	modules      *Modules
+	loopLabels   []*LoopLabel
+	labelCount   int
+	typeParams   []string
+	// Constraints on the type parameters of the generic function being parsed
+	typeParamConstraints map[string]TypeConstraint
 }
 
 func (p *Parser) parseError(text string, token Token) error {
-	return fmt.Errorf("%s:%d:%d: %s", p.fileNames[token.file], token.line+1, token.column, text)
+	return fmt.Errorf("%s:%d:%d: %s", p.modules.fileNames[token.file], token.line+1, token.column, text)
 }
 
 func (p *Parser) addImport(name string) {
@@ -116,8 +169,13 @@ func (s *Scope) setVariableUsed(name string) error {
 func (p *Parser) validateVariable(name string) bool {
 	symbol, found := p.currentScope.lookupSymbol(name)
 
+	// Functions can be used as values, also before they are declared
+	if !found && p.modules.functions[name] {
+		return true
+	}
+
 	// Symbol not found or was not a variable
-	if !found || symbol.category != VariableSymbol {
+	if !found || (symbol.category != VariableSymbol && symbol.category != ConstantSymbol && symbol.category != FunctionSymbol) {
 		return false
 	}
 
@@ -131,8 +189,28 @@ func (p *Parser) createVariableInCurrentScope(name string, typ Type) bool {
 	return p.currentScope.createSymbol(name, VariableSymbol, typ, &ParameterListNode{}, false)
 }
 
-func (p *Parser) createFunctionInCurrentScope(name string, paramsNode *ParameterListNode, returnType Type, fallible bool) bool {
-	return p.currentScope.createSymbol(name, FunctionSymbol, returnType, paramsNode, fallible)
+func (p *Parser) createFunctionInCurrentScope(name string, paramsNode *ParameterListNode, returnType Type, fallible bool, typeParams []string, typeParamConstraints map[string]TypeConstraint) bool {
+	if !p.currentScope.createSymbol(name, FunctionSymbol, returnType, paramsNode, fallible) {
+		return false
+	}
+	symbol := p.currentScope.symbols[name]
+	symbol.typeParams = typeParams
+	symbol.typeParamConstraints = typeParamConstraints
+	p.currentScope.symbols[name] = symbol
+	return true
+}
+
+func (p *Parser) createConstantInCurrentScope(name string, typ Type) bool {
+	return p.currentScope.createSymbol(name, ConstantSymbol, typ, &ParameterListNode{}, false)
+}
+
+func (p *Parser) isConstant(name string) bool {
+	symbol, found := p.currentScope.lookupSymbol(name)
+	return found && symbol.category == ConstantSymbol
+}
+
+func (p *Parser) createRecordInCurrentScope(name string, fieldsNode *ParameterListNode) bool {
+	return p.currentScope.createSymbol(name, RecordSymbol, TypeRecord{Name: name}, fieldsNode, false)
 }
 
 func (p *Parser) unusedVariables() []string {
@@ -230,7 +308,7 @@ func (p *Parser) parseTerm() (Node, error) {
 	if err != nil {
 		return &NoOpNode{}, err
 	}
-	for p.currentToken().kind == Plus || p.currentToken().kind == Minus {
+	for p.currentToken().kind == Plus || p.currentToken().kind == Minus || p.currentToken().kind == BitOr || p.currentToken().kind == BitXor {
 		opToken := p.consumeToken()
 		right, err := p.parseFactor()
 		if err != nil {
@@ -242,51 +320,121 @@ func (p *Parser) parseTerm() (Node, error) {
 }
 
 func (p *Parser) parseFactor() (Node, error) {
-	node, err := p.parsePrimary()
+	node, err := p.parseUnary()
 	if err != nil {
 		return &NoOpNode{}, err
 	}
 
-	for p.currentToken().kind == Mult || p.currentToken().kind == Div {
+	for {
+		switch p.currentToken().kind {
+		case Mult, Div, Modulo, IntDiv, BitAnd, ShiftLeft, ShiftRight:
+		default:
+			return node, nil
+		}
 		opToken := p.consumeToken()
-		right, err := p.parsePrimary()
+		right, err := p.parseUnary()
 		if err != nil {
 			return &NoOpNode{}, err
 		}
 		node = &BinOpNode{left: node, token: opToken, right: right}
 	}
-	return node, nil
+}
+
+// Unary minus binds looser than exponentiation: -2 ** 2 == -(2 ** 2)
+func (p *Parser) parseUnary() (Node, error) {
+	if p.currentToken().kind != Minus {
+		return p.parsePower()
+	}
+	op := p.consumeToken()
+	expr, err := p.parseUnary()
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+
+	// Negative number literals, eg. `-1`
+	if num, isNum := expr.(*NumNode); isNum && !strings.HasPrefix(num.token.str, "-") {
+		num.token.str = "-" + num.token.str
+		return num, nil
+	}
+	return &UnaryOpNode{token: op, expr: expr}, nil
+}
+
+// Exponentiation binds tighter than the other operators and is right associative: 2 ** 3 ** 2 == 2 ** 9
+func (p *Parser) parsePower() (Node, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	if p.currentToken().kind != Power {
+		return node, nil
+	}
+	opToken := p.consumeToken()
+	right, err := p.parseUnary()
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	return &BinOpNode{left: node, token: opToken, right: right}, nil
 }
 
 func (p *Parser) parsePrimary() (Node, error) {
 	switch p.currentToken().kind {
 
 	case OpenParen:
+		if p.isLambda() {
+			return p.parseLambda()
+		}
 		_ = p.consumeToken()
 		expr, err := p.parseExpr()
 		if err != nil {
 			return &NumNode{}, err
 		}
+		if p.currentToken().kind == Comma {
+			expr, err = p.parseTupleLiteral(expr)
+			if err != nil {
+				return &NoOpNode{}, err
+			}
+		}
 		_ = p.consumeToken()
 		return expr, nil
 
 	case Integer, Float:
 
 		node := &NumNode{token: p.consumeToken()}
+		return p.parseChain(node)
+
+	case Minus:
+		op := p.consumeToken()
+
+		// Negative number literals, eg. `-1`
+		if p.currentToken().kind == Integer || p.currentToken().kind == Float {
+			numToken := p.consumeToken()
+			numToken.str = "-" + numToken.str
+			return p.parseChain(&NumNode{token: numToken})
+		}
+
+		expr, err := p.parsePrimary()
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		return &UnaryOpNode{token: op, expr: expr}, nil
 
-		// Chained function call
-		if p.currentToken().kind == Period {
-			p.consumeToken() // .
-			chained, err := p.parseFunctionCall(node)
+	case Identifier:
+		// Typed map literal, eg. map[str]int{"a": 1}
+		if p.currentToken().str == "map" && p.peek(1).kind == OpenBracket {
+			mapLiteral, err := p.parseMapLiteral()
 			if err != nil {
 				return &NoOpNode{}, err
 			}
-			return chained, nil
+			return mapLiteral, nil
+		}
+		if p.atMatch() {
+			return p.parseMatch(true)
+		}
+		// Enum values, eg. `Shape.Circle(2.0)`
+		if p.modules.enums[p.currentToken().str] && p.peek(1).kind == Period {
+			return p.parseEnumValue()
 		}
 
-		return node, nil
-
-	case Identifier:
 		switch p.peek(1).kind {
 		case OpenParen: // Function call
 			functionCall, err := p.parseFunctionCall(nil)
@@ -294,23 +442,14 @@ func (p *Parser) parsePrimary() (Node, error) {
 				return &NoOpNode{}, err
 			}
 			return functionCall, nil
+		case FatArrow: // Lambda with a single parameter, eg. `x => x * 2`
+			return p.parseLambda()
 		default: // Variable
 			variable, err := p.parseVar(true)
 			if err != nil {
 				return &NoOpNode{}, err
 			}
-
-			// Chained function call
-			if p.currentToken().kind == Period {
-				p.consumeToken() // .
-				chained, err := p.parseFunctionCall(variable)
-				if err != nil {
-					return &NoOpNode{}, err
-				}
-				return chained, nil
-			}
-
-			return variable, nil
+			return p.parseChain(variable)
 		}
 
 	case Keyword:
@@ -325,25 +464,21 @@ func (p *Parser) parsePrimary() (Node, error) {
 				return &NoOpNode{}, err
 			}
 			return set, nil
+		case "print": // Eg. in the body of a lambda: `x => print(x)`
+			return p.parseFunctionCall(nil)
+		case "if":
+			return p.parseIfExpression()
 		default:
 			return &NoOpNode{}, p.parseError(fmt.Sprintf("invalid keyword in primary expression: %q", keyword), p.currentToken())
 		}
 
 	case StringLiteral:
 
-		node := &StringLiteralNode{token: p.consumeToken()}
-
-		// Chained function call
-		if p.currentToken().kind == Period {
-			p.consumeToken() // .
-			chained, err := p.parseFunctionCall(node)
-			if err != nil {
-				return &NoOpNode{}, err
-			}
-			return chained, nil
+		node, err := p.parseStringLiteral()
+		if err != nil {
+			return &NoOpNode{}, err
 		}
-
-		return node, nil
+		return p.parseChain(node)
 
 	case Not:
 		op := p.consumeToken()
@@ -360,13 +495,20 @@ func (p *Parser) parsePrimary() (Node, error) {
 		}
 	return slice, nil
 
+	case OpenCurly:
+		mapLiteral, err := p.parseMapLiteral()
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		return mapLiteral, nil
+
 	default:
 		return &NoOpNode{}, p.parseError(fmt.Sprintf("invalid initial token in expression: %q", p.currentToken().str), p.currentToken())
 	}
 }
 
 func (p *Parser) parseSetLiteral() (Node, error) {
-	_, err := p.expectToken(Keyword) // set
+	startToken, err := p.expectToken(Keyword) // set
 	if err != nil {
 		panic("UNREACHABLE")
 	}
@@ -385,14 +527,22 @@ func (p *Parser) parseSetLiteral() (Node, error) {
 		return &NoOpNode{}, p.parseError(fmt.Sprintf("set literal was not closed, missing )"), p.currentToken())
 	}
 
-	return &SetLiteralNode{elements: elements}, nil // FIXME
+	return &SetLiteralNode{token: startToken, elements: elements}, nil
 }
 
 func (p *Parser) parseSliceLiteral() (Node, error) {
-	_, err := p.expectToken(OpenBracket)
+	// Typed slice literals, eg. `[][]str{}`, can be empty
+	if p.peek(1).kind == CloseBracket {
+		return p.parseTypedSliceLiteral()
+	}
+
+	startToken, err := p.expectToken(OpenBracket)
 	if err != nil {
 		return &NoOpNode{}, p.parseError(fmt.Sprintf("error parsing slice literal: %v", err), p.currentToken())
 	}
+	if p.currentToken().kind == CloseBracket {
+		return &NoOpNode{}, p.parseError("cannot infer type of empty slice literal, use eg. []str{}", startToken)
+	}
 
 	elements, err := p.parseExprList(CloseBracket)
 	if err != nil {
@@ -404,7 +554,96 @@ func (p *Parser) parseSliceLiteral() (Node, error) {
 		return &NoOpNode{}, p.parseError(fmt.Sprintf("slice literal was not closed, missing ]"), p.currentToken())
 	}
 
-	return &SliceLiteralNode{elements: elements}, nil
+	return &SliceLiteralNode{token: startToken, elements: elements}, nil
+}
+
+// Parses slice literals with an explicit type, eg. `[]float{1, 2}`
+func (p *Parser) parseTypedSliceLiteral() (Node, error) {
+	startToken := p.currentToken()
+	typ, err := p.parseType()
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	_, err = p.expectToken(OpenCurly)
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+
+	var elements []Node
+	if p.currentToken().kind != CloseCurly {
+		elements, err = p.parseExprList(CloseCurly)
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+	}
+
+	_, err = p.expectToken(CloseCurly)
+	if err != nil {
+		return &NoOpNode{}, p.parseError(fmt.Sprintf("slice literal was not closed, missing }"), p.currentToken())
+	}
+
+	return &SliceLiteralNode{token: startToken, elements: elements, elementType: typ.(TypeSlice).ElementType}, nil
+}
+
+func (p *Parser) parseMapLiteral() (Node, error) {
+	startToken := p.currentToken()
+
+	// Optional type prefix: map[K]V{...}. Without it the types are inferred from the elements.
+	var keyType, valueType Type = TypeUndetermined{}, TypeUndetermined{}
+	if startToken.kind == Identifier {
+		typ, err := p.parseType()
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		mapType, isMap := typ.(TypeMap)
+		if !isMap {
+			return &NoOpNode{}, p.parseError(fmt.Sprintf("expected map type before map literal, got %s", typ), startToken)
+		}
+		keyType, valueType = mapType.KeyType, mapType.ValueType
+	}
+
+	_, err := p.expectToken(OpenCurly)
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+
+	var keys, values []Node
+	for p.currentToken().kind != CloseCurly && p.currentToken().kind != Eof {
+		key, err := p.parseExpr()
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		_, err = p.expectToken(Colon)
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		value, err := p.parseExpr()
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+
+		switch p.currentToken().kind {
+		case Comma:
+			p.consumeToken()
+		case CloseCurly:
+			break
+		default:
+			return &NoOpNode{}, p.parseError(fmt.Sprintf("failed to parse map literal, expected , or }, got %q", p.currentToken().str), p.currentToken())
+		}
+	}
+
+	_, err = p.expectToken(CloseCurly)
+	if err != nil {
+		return &NoOpNode{}, p.parseError(fmt.Sprintf("map literal was not closed, missing }"), p.currentToken())
+	}
+
+	if len(keys) == 0 && keyType == (TypeUndetermined{}) {
+		return &NoOpNode{}, p.parseError("cannot infer type of empty map literal, use eg. map[str]int{}", startToken)
+	}
+
+	return &MapLiteralNode{token: startToken, keys: keys, values: values, keyType: keyType, valueType: valueType}, nil
 }
 
 func (p *Parser) parseExprList(finalToken TokenKind) ([]Node, error) {
@@ -460,12 +699,29 @@ func (p *Parser) parseCompoundStatement(parameters []ParameterNode, returnType T
 	return &CompoundStatementNode{children: statements, unusedVars: p.unusedVariables(), scope: p.currentScope}, nil
 }
 
+// Parses a type annotation. Container types nest arbitrarily, eg. `[][]str` or `map[str][]set(int)`
 func (p *Parser) parseType() (Type, error) {
-	isSlice := false
-	if p.currentToken().kind == OpenBracket && p.peek(1).kind == CloseBracket {
+	switch p.currentToken().kind {
+	case OpenBracket:
 		p.consumeToken() // [
-		p.consumeToken() // ]
-		isSlice = true
+		_, err := p.expectToken(CloseBracket)
+		if err != nil {
+			return TypeUndetermined{}, err
+		}
+		elementType, err := p.parseType()
+		if err != nil {
+			return TypeUndetermined{}, err
+		}
+		return TypeSlice{ElementType: elementType}, nil
+	case OpenParen:
+		return p.parseTupleType()
+	case Keyword:
+		switch p.currentToken().str {
+		case "set":
+			return p.parseSetType()
+		case "fn":
+			return p.parseFunctionType()
+		}
 	}
 
 	typeToken, err := p.expectToken(Identifier)
@@ -473,115 +729,471 @@ func (p *Parser) parseType() (Type, error) {
 		return TypeUndetermined{}, err
 	}
 
-	var baseType Type
 	switch typeToken.str {
 	case "int":
-		baseType = TypeInt{}
+		return TypeInt{}, nil
 	case "float":
-		baseType = TypeFloat{}
+		return TypeFloat{}, nil
 	case "str":
-		baseType = TypeString{}
+		return TypeString{}, nil
 	case "bool":
-		baseType = TypeBool{}
+		return TypeBool{}, nil
+	case "map":
+		return p.parseMapType()
 	default:
-		return TypeUndetermined{}, p.parseError(fmt.Sprintf("expected type, got: %q", typeToken.str), p.currentToken())
-	}
-
-	if isSlice {
-		return TypeSlice{ElementType: baseType}, nil
-	}
-	return baseType, nil
-}
-
-func literalTokenType(token Token) (Type, error) {
-	switch token.kind {
-	case Integer:
-		return TypeInt{}, nil
-	case Float:
-		return TypeFloat{}, nil
-	case StringLiteral:
-		return TypeString{}, nil
-	case Keyword:
-		if token.str == "true" || token.str == "false" {
-			return TypeBool{}, nil
+		if slices.Contains(p.typeParams, typeToken.str) {
+			return TypeParam{Name: typeToken.str}, nil
+		}
+		if p.modules.enums[typeToken.str] {
+			return TypeEnum{Name: typeToken.str}, nil
 		}
+		// Any other name refers to a record, which is validated by the type checker
+		return TypeRecord{Name: typeToken.str}, nil
 	}
-	return TypeUndetermined{}, fmt.Errorf("expected literal, got %s (%q)", token.kind, token.str)
 }
 
-func (p *Parser) parseParameter() (Node, error) {
-	name, err := p.expectToken(Identifier)
-	if err != nil {
-		return &NoOpNode{}, err
-	}
-	typ, err := p.parseType()
+// Parses function types, eg. `fn(str, int) -> bool`. Without an arrow the function returns nothing.
+func (p *Parser) parseFunctionType() (Type, error) {
+	p.consumeToken() // fn
+	openToken, err := p.expectToken(OpenParen)
 	if err != nil {
-		return &NoOpNode{}, err
+		return TypeUndetermined{}, err
 	}
-	if p.currentToken().kind == Assign {
-		p.consumeToken()
-		defaultToken := p.consumeToken()
-		literalType, err := literalTokenType(defaultToken)
+	var params []Type
+	for p.currentToken().kind != CloseParen {
+		param, err := p.parseType()
 		if err != nil {
-			return &NoOpNode{}, p.parseError(fmt.Sprintf("invalid default argument: %v", err), defaultToken)
+			return TypeUndetermined{}, err
 		}
-		if typ == literalType {
-			return &ParameterNode{name: name.str, typ: typ, hasDefault: true, defaultValue: defaultToken.str}, nil
+		params = append(params, param)
+		if p.currentToken().kind == Comma {
+			p.consumeToken() // ,
+		} else if p.currentToken().kind != CloseParen {
+			return TypeUndetermined{}, p.parseError(fmt.Sprintf("expected \",\" or \")\" in function type, got %q", p.currentToken().str), p.currentToken())
 		}
-		return &NoOpNode{}, p.parseError(fmt.Sprintf("default argument has wrong type, expected %s, got %s (%q)", typ, literalType, defaultToken.str), defaultToken)
 	}
-	return &ParameterNode{name: name.str, typ: typ}, nil
-}
+	p.consumeToken() // )
+	if len(params) > maxTupleSize {
+		return TypeUndetermined{}, p.parseError(fmt.Sprintf("function types can have at most %d parameters", maxTupleSize), openToken)
+	}
 
-func (p *Parser) parseParameterList() (Node, error) {
-	var paramList []ParameterNode
-	for p.currentToken().kind != CloseParen {
-		param, err := p.parseParameter()
+	var returnType Type = TypeVoid{}
+	if p.currentToken().kind == RightArrow {
+		p.consumeToken() // ->
+		returnType, err = p.parseType()
 		if err != nil {
-			return &NoOpNode{}, err
-		}
-		paramList = append(paramList, *param.(*ParameterNode))
-		switch p.currentToken().kind {
-		case Comma:
-			p.consumeToken()
-		case CloseParen:
-			break
-		default:
-			return &NoOpNode{}, p.parseError(fmt.Sprintf("parameter declaration must be followed by \")\" or \",\", got %s", p.currentToken().str), p.currentToken())
+			return TypeUndetermined{}, err
 		}
 	}
-	return &ParameterListNode{parameters: paramList}, nil
+	return newFunctionType(params, returnType), nil
 }
 
-func (p *Parser) parseReturnType() (Type, error) {
-	typ, err := p.parseType()
-	if err != nil {
-		return TypeUndetermined{}, err
-	}
-	if p.currentToken().kind != OpenCurly {
-		return TypeUndetermined{}, p.parseError(fmt.Sprintf("expected \"{\" after return type declaration, got %q", p.currentToken().str), p.currentToken())
+// Checks if the parenthesis at the current token starts the parameters of a lambda, eg. `(a, b) => a + b`
+func (p *Parser) isLambda() bool {
+	depth := 0
+	for i := p.tokenIdx; i < len(p.tokens); i++ {
+		switch p.tokens[i].kind {
+		case OpenParen:
+			depth++
+		case CloseParen:
+			depth--
+			if depth == 0 {
+				return i+1 < len(p.tokens) && p.tokens[i+1].kind == FatArrow
+			}
+		case Eof:
+			return false
+		}
 	}
-	return typ, nil
-
+	return false
 }
 
-func (p *Parser) parseFunction() (Node, error) {
-	_, err := p.expectToken(Keyword) // fn
-	if err != nil {
-		return &NoOpNode{}, err
+// Parses lambdas, eg. `x => x * 2` or `(name str, n int) => name * n`. Parameters without
+// a type get the types of the function type expected where the lambda is used.
+func (p *Parser) parseLambda() (Node, error) {
+	var parameters []ParameterNode
+	if p.currentToken().kind == Identifier {
+		parameters = append(parameters, ParameterNode{name: p.consumeToken().str, typ: TypeUndetermined{}})
+	} else {
+		p.consumeToken() // (
+		for p.currentToken().kind != CloseParen {
+			name, err := p.expectToken(Identifier)
+			if err != nil {
+				return &NoOpNode{}, err
+			}
+			var typ Type = TypeUndetermined{}
+			if p.currentToken().kind != Comma && p.currentToken().kind != CloseParen {
+				typ, err = p.parseType()
+				if err != nil {
+					return &NoOpNode{}, err
+				}
+			}
+			parameters = append(parameters, ParameterNode{name: name.str, typ: typ})
+			if p.currentToken().kind == Comma {
+				p.consumeToken() // ,
+			}
+		}
+		p.consumeToken() // )
 	}
-	functionName, err := p.expectToken(Identifier) // function name
+	arrowToken, err := p.expectToken(FatArrow)
 	if err != nil {
 		return &NoOpNode{}, err
 	}
-
-	fallible := false
-	if p.currentToken().kind == QuestionMark {
-		fallible = true
-		p.consumeToken() // ?
+	if len(parameters) > maxTupleSize {
+		return &NoOpNode{}, p.parseError(fmt.Sprintf("lambdas can have at most %d parameters", maxTupleSize), arrowToken)
 	}
 
-	_, err = p.expectToken(OpenParen)
+	p.newScope(parameters, TypeUndetermined{}, false)
+	body, err := p.parseExpr()
+	scope := p.currentScope
+	p.leaveScope()
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	return &LambdaNode{token: arrowToken, parameters: parameters, body: body, scope: scope}, nil
+}
+
+// Parses set types, eg. `set(str)`
+func (p *Parser) parseSetType() (Type, error) {
+	p.consumeToken() // set
+	_, err := p.expectToken(OpenParen)
+	if err != nil {
+		return TypeUndetermined{}, err
+	}
+	elementToken := p.currentToken()
+	elementType, err := p.parseType()
+	if err != nil {
+		return TypeUndetermined{}, err
+	}
+	if !isMapKey(elementType) {
+		return TypeUndetermined{}, p.parseError(fmt.Sprintf("invalid set element type %s, must be int, float or str", elementType), elementToken)
+	}
+	constrainTypeParam(p.typeParamConstraints, elementType, OrderedConstraint)
+	_, err = p.expectToken(CloseParen)
+	if err != nil {
+		return TypeUndetermined{}, err
+	}
+	return TypeSet{ElementType: elementType}, nil
+}
+
+// Parses tuple types, eg. `(int, str)`
+func (p *Parser) parseTupleType() (Type, error) {
+	openToken, err := p.expectToken(OpenParen)
+	if err != nil {
+		return TypeUndetermined{}, err
+	}
+	var types []Type
+	for {
+		typ, err := p.parseType()
+		if err != nil {
+			return TypeUndetermined{}, err
+		}
+		types = append(types, typ)
+		if p.currentToken().kind != Comma {
+			break
+		}
+		p.consumeToken() // ,
+	}
+	_, err = p.expectToken(CloseParen)
+	if err != nil {
+		return TypeUndetermined{}, err
+	}
+	if len(types) < 2 || len(types) > maxTupleSize {
+		return TypeUndetermined{}, p.parseError(fmt.Sprintf("tuples must have between 2 and %d elements", maxTupleSize), openToken)
+	}
+	return newTupleType(types), nil
+}
+
+// Parses the remaining elements of a tuple literal after its first element, eg. `, b, c` in `a, b, c`
+func (p *Parser) parseTupleLiteral(first Node) (Node, error) {
+	commaToken := p.currentToken()
+	elements := []Node{first}
+	for p.currentToken().kind == Comma {
+		p.consumeToken() // ,
+		element, err := p.parseExpr()
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		elements = append(elements, element)
+	}
+	if len(elements) > maxTupleSize {
+		return &NoOpNode{}, p.parseError(fmt.Sprintf("tuples must have between 2 and %d elements", maxTupleSize), commaToken)
+	}
+	return &TupleLiteralNode{token: commaToken, elements: elements}, nil
+}
+
+// Parses the variables of a destructured tuple in loops, eg. `(name, count)`
+func (p *Parser) parseDestructureTargets() ([]VarNode, error) {
+	openToken, err := p.expectToken(OpenParen)
+	if err != nil {
+		return nil, err
+	}
+	var targets []VarNode
+	for {
+		token, err := p.expectToken(Identifier)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, VarNode{token: token})
+		if p.currentToken().kind != Comma {
+			break
+		}
+		p.consumeToken() // ,
+	}
+	_, err = p.expectToken(CloseParen)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) < 2 {
+		return nil, p.parseError("destructuring needs at least two variables", openToken)
+	}
+	return targets, nil
+}
+
+// Returns the loop variable for iterating over tuples that are destructured, together with
+// the destructured variables as parameters of the loop body
+func destructureParameters(targets []VarNode) (*VarNode, []ParameterNode) {
+	variable := &VarNode{token: Token{kind: Identifier, str: "___tuple"}}
+	params := []ParameterNode{{name: variable.token.str, typ: TypeUndetermined{}}}
+	for _, target := range targets {
+		if target.token.str != "_" {
+			params = append(params, ParameterNode{name: target.token.str, typ: TypeUndetermined{}})
+		}
+	}
+	return variable, params
+}
+
+// Parses destructuring assignments such as `count, total = stats(values)`, `_` ignores a value
+func (p *Parser) parseDestructure() (Node, error) {
+	var targets []VarNode
+	var declarations []bool
+	for {
+		token, err := p.expectToken(Identifier)
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		declaration := false
+		if token.str != "_" {
+			if p.isConstant(token.str) {
+				return &NoOpNode{}, p.parseError(fmt.Sprintf("cannot assign to constant %q", token.str), token)
+			}
+			_, exists := p.currentScope.lookupSymbol(token.str)
+			if !exists {
+				_ = p.createVariableInCurrentScope(token.str, TypeUndetermined{})
+			}
+			declaration = !exists
+		}
+		targets = append(targets, VarNode{token: token})
+		declarations = append(declarations, declaration)
+		if p.currentToken().kind != Comma {
+			break
+		}
+		p.consumeToken() // ,
+	}
+
+	assignToken, err := p.expectToken(Assign)
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	right, err := p.parseExpr()
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	if p.currentToken().kind == Comma {
+		right, err = p.parseTupleLiteral(right)
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+	}
+	return &DestructureNode{token: assignToken, targets: targets, declarations: declarations, right: right}, nil
+}
+
+// Parses the `[K]V` part of a map type, the `map` identifier is already consumed
+func (p *Parser) parseMapType() (Type, error) {
+	_, err := p.expectToken(OpenBracket)
+	if err != nil {
+		return TypeUndetermined{}, err
+	}
+	keyToken := p.currentToken()
+	keyType, err := p.parseType()
+	if err != nil {
+		return TypeUndetermined{}, err
+	}
+	if !isMapKey(keyType) {
+		return TypeUndetermined{}, p.parseError(fmt.Sprintf("invalid map key type %s, must be int, float or str", keyType), keyToken)
+	}
+	constrainTypeParam(p.typeParamConstraints, keyType, OrderedConstraint)
+	_, err = p.expectToken(CloseBracket)
+	if err != nil {
+		return TypeUndetermined{}, err
+	}
+	valueType, err := p.parseType()
+	if err != nil {
+		return TypeUndetermined{}, err
+	}
+	return TypeMap{KeyType: keyType, ValueType: valueType}, nil
+}
+
+// Parses a string literal, which may contain interpolated expressions: `"{name} has {len(row)} fields"`
+func (p *Parser) parseStringLiteral() (Node, error) {
+	first := &StringLiteralNode{token: p.consumeToken()}
+	if p.currentToken().kind != InterpolationStart {
+		return first, nil
+	}
+
+	parts := []Node{first}
+	for p.currentToken().kind == InterpolationStart {
+		p.consumeToken() // {
+		if p.currentToken().kind == InterpolationEnd {
+			return &NoOpNode{}, p.parseError("empty expression in string interpolation", p.currentToken())
+		}
+		expr, err := p.parseExpr()
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		if p.currentToken().kind != InterpolationEnd {
+			return &NoOpNode{}, p.parseError(fmt.Sprintf("expected \"}\" after interpolated expression, got %q", p.currentToken().str), p.currentToken())
+		}
+		p.consumeToken() // }
+		literal, err := p.expectToken(StringLiteral)
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		parts = append(parts, expr, &StringLiteralNode{token: literal})
+	}
+	return &InterpolatedStringNode{token: first.token, parts: parts}, nil
+}
+
+func literalTokenType(token Token) (Type, error) {
+	switch token.kind {
+	case Integer:
+		return TypeInt{}, nil
+	case Float:
+		return TypeFloat{}, nil
+	case StringLiteral:
+		return TypeString{}, nil
+	case Keyword:
+		if token.str == "true" || token.str == "false" {
+			return TypeBool{}, nil
+		}
+	}
+	return TypeUndetermined{}, fmt.Errorf("expected literal, got %s (%q)", token.kind, token.str)
+}
+
+func (p *Parser) parseParameter() (Node, error) {
+	name, err := p.expectToken(Identifier)
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	typ, err := p.parseType()
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	if p.currentToken().kind == Assign {
+		p.consumeToken()
+		negative := false
+		if p.currentToken().kind == Minus {
+			p.consumeToken() // -
+			negative = true
+		}
+		defaultToken := p.consumeToken()
+		literalType, err := literalTokenType(defaultToken)
+		if err != nil {
+			return &NoOpNode{}, p.parseError(fmt.Sprintf("invalid default argument: %v", err), defaultToken)
+		}
+		if negative {
+			if defaultToken.kind != Integer && defaultToken.kind != Float {
+				return &NoOpNode{}, p.parseError(fmt.Sprintf("invalid default argument: cannot negate %q", defaultToken.str), defaultToken)
+			}
+			defaultToken.str = "-" + defaultToken.str
+		}
+		if typ == literalType {
+			return &ParameterNode{name: name.str, typ: typ, hasDefault: true, defaultValue: defaultToken.str}, nil
+		}
+		return &NoOpNode{}, p.parseError(fmt.Sprintf("default argument has wrong type, expected %s, got %s (%q)", typ, literalType, defaultToken.str), defaultToken)
+	}
+	return &ParameterNode{name: name.str, typ: typ}, nil
+}
+
+func (p *Parser) parseParameterList() (Node, error) {
+	var paramList []ParameterNode
+	for p.currentToken().kind != CloseParen {
+		param, err := p.parseParameter()
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		paramList = append(paramList, *param.(*ParameterNode))
+		switch p.currentToken().kind {
+		case Comma:
+			p.consumeToken()
+		case CloseParen:
+			break
+		default:
+			return &NoOpNode{}, p.parseError(fmt.Sprintf("parameter declaration must be followed by \")\" or \",\", got %s", p.currentToken().str), p.currentToken())
+		}
+	}
+	return &ParameterListNode{parameters: paramList}, nil
+}
+
+func (p *Parser) parseReturnType() (Type, error) {
+	// Generator functions declare the type of the values they yield: `-> gen []str`
+	isGenerator := false
+	if p.currentToken().kind == Keyword && p.currentToken().str == "gen" {
+		p.consumeToken() // gen
+		isGenerator = true
+	}
+	typ, err := p.parseType()
+	if err != nil {
+		return TypeUndetermined{}, err
+	}
+	if isGenerator {
+		typ = TypeGenerator{ElementType: typ}
+	}
+	if p.currentToken().kind != OpenCurly {
+		return TypeUndetermined{}, p.parseError(fmt.Sprintf("expected \"{\" after return type declaration, got %q", p.currentToken().str), p.currentToken())
+	}
+	return typ, nil
+
+}
+
+func (p *Parser) parseFunction() (Node, error) {
+	_, err := p.expectToken(Keyword) // fn
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	functionName, err := p.expectToken(Identifier) // function name
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+
+	fallible := false
+	if p.currentToken().kind == QuestionMark {
+		fallible = true
+		p.consumeToken() // ?
+	}
+
+	var typeParams []string
+	if p.currentToken().kind == Less {
+		typeParams, err = p.parseTypeParams()
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+	}
+
+	// Type parameters can be used in the signature and anywhere in the body
+	outerTypeParams := p.typeParams
+	p.typeParams = append(slices.Clone(outerTypeParams), typeParams...)
+	defer func() { p.typeParams = outerTypeParams }()
+
+	// Nested functions are never generic, the type parameters they use belong to the outer function
+	var typeParamConstraints map[string]TypeConstraint
+	if len(typeParams) > 0 {
+		outerConstraints := p.typeParamConstraints
+		typeParamConstraints = make(map[string]TypeConstraint)
+		p.typeParamConstraints = typeParamConstraints
+		defer func() { p.typeParamConstraints = outerConstraints }()
+	}
+
+	_, err = p.expectToken(OpenParen)
 	if err != nil {
 		return &NoOpNode{}, err
 	}
@@ -607,17 +1219,405 @@ func (p *Parser) parseFunction() (Node, error) {
 		returnType = TypeVoid{}
 	}
 
-	isNew := p.createFunctionInCurrentScope(functionName.str, parameterList.(*ParameterListNode), returnType, fallible)
+	isNew := p.createFunctionInCurrentScope(functionName.str, parameterList.(*ParameterListNode), returnType, fallible, typeParams, typeParamConstraints)
 	if !isNew {
 		return &NoOpNode{}, p.parseError(fmt.Sprintf("function with name %q already exists in the same scope", functionName.str), functionName)
 	}
 
 	functionBody, err := p.parseCompoundStatement(parameterList.(*ParameterListNode).parameters, returnType, fallible)
 	if err != nil {
-		return &NoOpNode{}, err
+		return &NoOpNode{}, err
+	}
+
+	return &FunctionNode{token: functionName, parameters: parameterList, body: functionBody, returnType: returnType, fallible: fallible, typeParams: typeParams, typeParamConstraints: typeParamConstraints}, nil
+}
+
+// Parses the type parameters of a generic function, eg. `<K, V>`
+func (p *Parser) parseTypeParams() ([]string, error) {
+	p.consumeToken() // <
+	var typeParams []string
+	for {
+		nameToken, err := p.expectToken(Identifier)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case slices.Contains([]string{"int", "float", "str", "bool", "map"}, nameToken.str):
+			return nil, p.parseError(fmt.Sprintf("%q is a builtin type and cannot be used as a type parameter", nameToken.str), nameToken)
+		case slices.Contains(typeParams, nameToken.str) || slices.Contains(p.typeParams, nameToken.str):
+			return nil, p.parseError(fmt.Sprintf("duplicate type parameter %q", nameToken.str), nameToken)
+		}
+		typeParams = append(typeParams, nameToken.str)
+		if p.currentToken().kind != Comma {
+			break
+		}
+		p.consumeToken() // ,
+	}
+	_, err := p.expectToken(Greater)
+	if err != nil {
+		return nil, err
+	}
+	return typeParams, nil
+}
+
+func (p *Parser) parseConst() (Node, error) {
+	_, err := p.expectToken(Keyword) // const
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	name, err := p.expectToken(Identifier)
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	_, err = p.expectToken(Assign)
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+
+	// Only literals are allowed as constant values
+	valueToken := p.currentToken()
+	if valueToken.kind == Minus && (p.peek(1).kind == Integer || p.peek(1).kind == Float) {
+		valueToken = p.peek(1)
+	}
+	typ, err := literalTokenType(valueToken)
+	if err != nil {
+		return &NoOpNode{}, p.parseError(fmt.Sprintf("invalid constant value: %v", err), valueToken)
+	}
+	if p.peek(1).kind == InterpolationStart {
+		return &NoOpNode{}, p.parseError("invalid constant value: interpolated strings are not literals", valueToken)
+	}
+	value, err := p.parsePrimary()
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+
+	isNew := p.createConstantInCurrentScope(name.str, typ)
+	if !isNew {
+		return &NoOpNode{}, p.parseError(fmt.Sprintf("%q is already declared", name.str), name)
+	}
+	return &ConstNode{token: name, expr: value, typ: typ}, nil
+}
+
+func (p *Parser) parseRecord() (Node, error) {
+	_, err := p.expectToken(Keyword) // record
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	recordName, err := p.expectToken(Identifier)
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	_, err = p.expectToken(OpenCurly)
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+
+	// Fields are declared like parameters, separated by commas or just whitespace
+	var fields []ParameterNode
+	fieldNames := make(map[string]bool)
+	for p.currentToken().kind != CloseCurly {
+		fieldToken := p.currentToken()
+		field, err := p.parseParameter()
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		fieldNode := field.(*ParameterNode)
+		if fieldNames[fieldNode.name] {
+			return &NoOpNode{}, p.parseError(fmt.Sprintf("duplicate field %q in record %q", fieldNode.name, recordName.str), fieldToken)
+		}
+		fieldNames[fieldNode.name] = true
+		fields = append(fields, *fieldNode)
+		if p.currentToken().kind == Comma {
+			p.consumeToken()
+		}
+	}
+
+	_, err = p.expectToken(CloseCurly)
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+
+	fieldList := &ParameterListNode{parameters: fields}
+	isNew := p.createRecordInCurrentScope(recordName.str, fieldList)
+	if !isNew {
+		return &NoOpNode{}, p.parseError(fmt.Sprintf("record or function with name %q already exists", recordName.str), recordName)
+	}
+	return &RecordNode{token: recordName, fields: fieldList}, nil
+}
+
+func (p *Parser) parseEnum() (Node, error) {
+	_, err := p.expectToken(Keyword) // enum
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	enumName, err := p.expectToken(Identifier)
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	_, err = p.expectToken(OpenCurly)
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+
+	// Variants are separated by commas or just whitespace, and may have payload fields
+	// declared like parameters, eg. `Circle(r float)`
+	var variants []EnumVariant
+	var variantNames []string
+	for p.currentToken().kind != CloseCurly {
+		variantToken, err := p.expectToken(Identifier)
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		if slices.Contains(variantNames, variantToken.str) {
+			return &NoOpNode{}, p.parseError(fmt.Sprintf("duplicate variant %q in enum %q", variantToken.str, enumName.str), variantToken)
+		}
+		fields := &ParameterListNode{}
+		if p.currentToken().kind == OpenParen {
+			p.consumeToken() // (
+			fieldList, err := p.parseParameterList()
+			if err != nil {
+				return &NoOpNode{}, err
+			}
+			_, err = p.expectToken(CloseParen)
+			if err != nil {
+				return &NoOpNode{}, err
+			}
+			fields = fieldList.(*ParameterListNode)
+		}
+		variants = append(variants, EnumVariant{token: variantToken, name: variantToken.str, fields: fields})
+		variantNames = append(variantNames, variantToken.str)
+		if p.currentToken().kind == Comma {
+			p.consumeToken()
+		}
+	}
+
+	_, err = p.expectToken(CloseCurly)
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	if len(variants) == 0 {
+		return &NoOpNode{}, p.parseError(fmt.Sprintf("enum %q has no variants", enumName.str), enumName)
+	}
+
+	enumType := TypeEnum{Name: enumName.str}
+	if !p.currentScope.createSymbol(enumName.str, EnumSymbol, enumType, &ParameterListNode{}, false) {
+		return &NoOpNode{}, p.parseError(fmt.Sprintf("enum, record or function with name %q already exists", enumName.str), enumName)
+	}
+	symbol := p.currentScope.symbols[enumName.str]
+	symbol.variants = variantNames
+	p.currentScope.symbols[enumName.str] = symbol
+	for _, variant := range variants {
+		p.currentScope.createSymbol(enumName.str+"."+variant.name, VariantSymbol, enumType, variant.fields, false)
+	}
+	return &EnumNode{token: enumName, variants: variants}, nil
+}
+
+// Parses the construction of an enum value, eg. `Shape.Circle(2.0)` or `Shape.Empty`. It
+// becomes a call of the variant, which is declared as `Shape.Circle`.
+func (p *Parser) parseEnumValue() (Node, error) {
+	enumToken := p.consumeToken()
+	p.consumeToken() // .
+	variantToken, err := p.expectToken(Identifier)
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	name := enumToken.str + "." + variantToken.str
+	symbol, found := p.currentScope.lookupSymbol(name)
+	if !found || symbol.category != VariantSymbol {
+		return &NoOpNode{}, p.parseError(fmt.Sprintf("enum %q has no variant %q", enumToken.str, variantToken.str), variantToken)
+	}
+
+	var arguments []Node
+	if p.currentToken().kind == OpenParen {
+		arguments, err = p.parseArgumentList(nil)
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		_, err = p.expectToken(CloseParen)
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+	}
+	call := &FunctionCallNode{token: variantToken, name: name, arguments: arguments}
+	return p.parseChain(call)
+}
+
+// Whether the current identifier starts a `match`. It is not a keyword, as `match()` is also a builtin.
+func (p *Parser) atMatch() bool {
+	if p.currentToken().kind != Identifier || p.currentToken().str != "match" {
+		return false
+	}
+	switch p.peek(1).kind {
+	case Identifier, Integer, Float, StringLiteral, Minus:
+		return true
+	case Keyword:
+		return p.peek(1).str == "true" || p.peek(1).str == "false"
+	}
+	return false
+}
+
+// Parses a match statement or, when used as a value, a match expression:
+//
+//	match shape {
+//	   Shape.Circle(r) => print("circle", r)
+//	   Shape.Square(_) => { print("square") }
+//	   _ => print("nothing")
+//	}
+func (p *Parser) parseMatch(expression bool) (Node, error) {
+	matchToken := p.consumeToken() // match
+	subject, err := p.parseExpr()
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	_, err = p.expectToken(OpenCurly)
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+
+	var arms []MatchArm
+	for p.currentToken().kind != CloseCurly {
+		pattern, err := p.parseMatchPattern()
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		_, err = p.expectToken(FatArrow)
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+
+		// Payload bindings are declared in the scope of the arm, their types are set by the type checker
+		var bindings []ParameterNode
+		for _, name := range pattern.bindings {
+			if name != "_" {
+				bindings = append(bindings, ParameterNode{name: name, typ: TypeUndetermined{}})
+			}
+		}
+
+		arm := MatchArm{pattern: pattern}
+		switch {
+		case expression && p.currentToken().kind == OpenCurly:
+			return &NoOpNode{}, p.parseError("the arms of a match used as a value must be expressions, not blocks", p.currentToken())
+		case expression:
+			p.newScope(bindings, NoReturn{}, false)
+			arm.body, err = p.parseExpr()
+			arm.scope = p.currentScope
+			p.leaveScope()
+		case p.currentToken().kind == OpenCurly:
+			arm.body, err = p.parseCompoundStatement(bindings, NoReturn{}, false)
+		default:
+			// A single statement, eg. `_ => print("none")`
+			p.newScope(bindings, NoReturn{}, false)
+			var statement Node
+			statement, err = p.parseStatement()
+			arm.body = &CompoundStatementNode{children: []Node{statement}, unusedVars: p.unusedVariables(), scope: p.currentScope}
+			p.leaveScope()
+		}
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		arms = append(arms, arm)
+
+		if p.currentToken().kind == Comma {
+			p.consumeToken()
+		}
+	}
+	p.consumeToken() // }
+
+	if len(arms) == 0 {
+		return &NoOpNode{}, p.parseError("match has no arms", matchToken)
+	}
+	return &MatchNode{token: matchToken, subject: subject, arms: arms, expression: expression}, nil
+}
+
+func (p *Parser) parseMatchPattern() (MatchPattern, error) {
+	token := p.currentToken()
+	switch {
+	case token.kind == Identifier && token.str == "_":
+		p.consumeToken()
+		return MatchPattern{token: token, kind: WildcardPattern}, nil
+
+	// Regexes, eg. `re"^#"`
+	case token.kind == Identifier && token.str == "re" && p.peek(1).kind == StringLiteral:
+		p.consumeToken() // re
+		regex, err := p.parseStringLiteral()
+		if err != nil {
+			return MatchPattern{}, err
+		}
+		if _, isLiteral := regex.(*StringLiteralNode); !isLiteral {
+			return MatchPattern{}, p.parseError("regex patterns cannot be interpolated", token)
+		}
+		return MatchPattern{token: token, kind: RegexPattern, value: regex}, nil
+
+	// Enum variants, eg. `Shape.Circle(r)` or `Shape.Empty`
+	case token.kind == Identifier && p.peek(1).kind == Period:
+		p.consumeToken() // enum
+		p.consumeToken() // .
+		variantToken, err := p.expectToken(Identifier)
+		if err != nil {
+			return MatchPattern{}, err
+		}
+		pattern := MatchPattern{token: variantToken, kind: VariantPattern, enum: token.str, variant: variantToken.str}
+		if p.currentToken().kind == OpenParen {
+			p.consumeToken() // (
+			for p.currentToken().kind != CloseParen {
+				binding, err := p.expectToken(Identifier)
+				if err != nil {
+					return MatchPattern{}, err
+				}
+				if binding.str != "_" && slices.Contains(pattern.bindings, binding.str) {
+					return MatchPattern{}, p.parseError(fmt.Sprintf("%q is bound more than once", binding.str), binding)
+				}
+				pattern.bindings = append(pattern.bindings, binding.str)
+				if p.currentToken().kind == Comma {
+					p.consumeToken()
+				} else if p.currentToken().kind != CloseParen {
+					return MatchPattern{}, p.parseError(fmt.Sprintf("expected \",\" or \")\" in pattern, got %q", p.currentToken().str), p.currentToken())
+				}
+			}
+			p.consumeToken() // )
+		}
+		return pattern, nil
+	}
+
+	// Literals, and ranges of numbers such as `1..10` or `1..=9`
+	value, err := p.parseLiteralPattern()
+	if err != nil {
+		return MatchPattern{}, err
+	}
+	if p.currentToken().kind != Range && p.currentToken().kind != RangeInclusive {
+		return MatchPattern{token: token, kind: LiteralPattern, value: value}, nil
+	}
+	inclusive := p.consumeToken().kind == RangeInclusive
+	to, err := p.parseLiteralPattern()
+	if err != nil {
+		return MatchPattern{}, err
 	}
+	return MatchPattern{token: token, kind: RangePattern, value: value, to: to, inclusive: inclusive}, nil
+}
 
-	return &FunctionNode{token: functionName, parameters: parameterList, body: functionBody, returnType: returnType, fallible: fallible}, nil
+func (p *Parser) parseLiteralPattern() (Node, error) {
+	token := p.currentToken()
+	switch {
+	case token.kind == Integer || token.kind == Float:
+		return &NumNode{token: p.consumeToken()}, nil
+	case token.kind == Minus && (p.peek(1).kind == Integer || p.peek(1).kind == Float):
+		p.consumeToken() // -
+		numToken := p.consumeToken()
+		numToken.str = "-" + numToken.str
+		return &NumNode{token: numToken}, nil
+	case token.kind == StringLiteral:
+		literal, err := p.parseStringLiteral()
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		if _, isLiteral := literal.(*StringLiteralNode); !isLiteral {
+			return &NoOpNode{}, p.parseError("string patterns cannot be interpolated", token)
+		}
+		return literal, nil
+	case token.kind == Keyword && (token.str == "true" || token.str == "false"):
+		return &BoolNode{token: p.consumeToken()}, nil
+	}
+	return &NoOpNode{}, p.parseError(fmt.Sprintf("invalid pattern %q, expected a literal, a range, a regex, an enum variant or _", token.str), token)
 }
 
 func (p *Parser) parseArgumentList(self Node) ([]Node, error) {
@@ -674,6 +1674,15 @@ func (p *Parser) parseArgumentList(self Node) ([]Node, error) {
 	return arguments, nil
 }
 
+// Reports whether a call to name refers to a builtin. Symbols in scope, such as
+// parameters and variables holding functions, shadow builtins of the same name.
+func (p *Parser) isBuiltinCall(name string) bool {
+	if _, found := p.currentScope.lookupSymbol(name); found {
+		return false
+	}
+	return isBuiltin(name)
+}
+
 func (p *Parser) parseFunctionCall(self Node) (Node, error) {
 	var functionToken Token
 	var err error
@@ -708,35 +1717,49 @@ func (p *Parser) parseFunctionCall(self Node) (Node, error) {
 	}
 
 	errorHandled := false
+	var errorBody Node
 	if p.currentToken().kind == QuestionMark {
 		errorHandled = true
 		p.consumeToken() // ?
 		if p.currentToken().kind == OpenCurly {
 			errVariable := &ParameterNode{name: "err", typ: TypeString{}}
-			errBody, err := p.parseCompoundStatement([]ParameterNode{*errVariable}, NoReturn{}, false)
+			errorBody, err = p.parseCompoundStatement([]ParameterNode{*errVariable}, NoReturn{}, false)
 			if err != nil {
 				return &NoOpNode{}, err
 			}
-			return &FunctionCallNode{name: functionToken.str, arguments: argumentList, errorHandled: true, errorBody: errBody}, nil
 		}
 	}
 
-	// Special case for generator build-ins such as read()
-	if isBuiltin(functionToken.str) && p.currentToken().kind == RightArrow {
+	// Generator calls such as `read(path) -> line { }`. Whether a user defined
+	// function is a generator is only known after type checking.
+	if p.currentToken().kind == RightArrow {
 
 		arrowToken := p.consumeToken() // ->
 
-		_, isGenerator := builtins[functionToken.str].returnType.(TypeGenerator)
-		if !isGenerator {
-			return &NoOpNode{}, p.parseError(fmt.Sprintf("cannot put \"->\" after non-generator function %q", functionToken.str), arrowToken)
+		if p.isBuiltinCall(functionToken.str) {
+			_, isGenerator := builtins[functionToken.str].returnType.(TypeGenerator)
+			if !isGenerator {
+				return &NoOpNode{}, p.parseError(fmt.Sprintf("cannot put \"->\" after non-generator function %q", functionToken.str), arrowToken)
+			}
 		}
 
-		variable, err := p.parseVar(false)
-		if err != nil {
-			return &NoOpNode{}, err
+		var variable Node
+		var destructure []VarNode
+		var generatorParams []ParameterNode
+		if p.currentToken().kind == OpenParen {
+			destructure, err = p.parseDestructureTargets()
+			if err != nil {
+				return &NoOpNode{}, err
+			}
+			variable, generatorParams = destructureParameters(destructure)
+		} else {
+			variable, err = p.parseVar(false)
+			if err != nil {
+				return &NoOpNode{}, err
+			}
+			controlVariable := &ParameterNode{name: variable.(*VarNode).token.str, typ: TypeUndetermined{}}
+			generatorParams = []ParameterNode{*controlVariable}
 		}
-		controlVariable := &ParameterNode{name: variable.(*VarNode).token.str, typ: TypeUndetermined{}}
-		generatorParams := []ParameterNode{*controlVariable}
 
 		// Parse optional index variable
 		var idxVariable Node
@@ -763,37 +1786,59 @@ func (p *Parser) parseFunctionCall(self Node) (Node, error) {
 		if hasIdx {
 			idxVariableNode = idxVariable.(*VarNode)
 		}
-		return &FunctionCallNode{name: functionToken.str, arguments: argumentList, isBuiltin: isBuiltin(functionToken.str), errorHandled: errorHandled, generatorVar: *variableNode, generatorBody: body, generatorHasIdx: hasIdx, generatorIdxVar: *idxVariableNode}, nil
+		return &FunctionCallNode{token: functionToken, name: functionToken.str, arguments: argumentList, isBuiltin: p.isBuiltinCall(functionToken.str), errorHandled: errorHandled, errorBody: errorBody, generatorVar: *variableNode, generatorBody: body, generatorHasIdx: hasIdx, generatorIdxVar: *idxVariableNode, generatorDestructure: destructure}, nil
+	}
+
+	if errorBody != nil {
+		return &FunctionCallNode{token: functionToken, name: functionToken.str, arguments: argumentList, isBuiltin: p.isBuiltinCall(functionToken.str), errorHandled: true, errorBody: errorBody}, nil
 	}
 
-	functionCall := &FunctionCallNode{name: functionToken.str, arguments: argumentList, isBuiltin: isBuiltin(functionToken.str), errorHandled: errorHandled}
+	functionCall := &FunctionCallNode{token: functionToken, name: functionToken.str, arguments: argumentList, isBuiltin: p.isBuiltinCall(functionToken.str), errorHandled: errorHandled}
+	return p.parseChain(functionCall)
+}
 
-	// Chained function call
-	if p.currentToken().kind == Period {
+// Parses chained function calls and field accesses following an expression. A name
+// followed by "(" is a chained call (`row.double()`), otherwise it's a field (`row.name`).
+func (p *Parser) parseChain(node Node) (Node, error) {
+	for p.currentToken().kind == Period {
 		p.consumeToken() // .
-		chained, err := p.parseFunctionCall(functionCall)
+		if p.currentToken().kind == Identifier && p.peek(1).kind != OpenParen {
+			node = &FieldAccessNode{token: p.consumeToken(), expr: node}
+			continue
+		}
+		chained, err := p.parseFunctionCall(node)
 		if err != nil {
 			return &NoOpNode{}, err
 		}
 		return chained, nil
 	}
-
-	return functionCall, nil
+	return node, nil
 }
 
 func (p *Parser) parseReturn() (Node, error) {
 
-	_, err := p.expectToken(Keyword) // return
+	returnToken, err := p.expectToken(Keyword) // return
 	if err != nil {
 		return &NoOpNode{}, err
 	}
 
+	// A bare return ends a void function or a generator
+	if p.currentToken().kind == CloseCurly {
+		return &ReturnNode{token: returnToken, expr: &NoOpNode{}}, nil
+	}
+
 	expr, err := p.parseExpr()
 	if err != nil {
 		return &NoOpNode{}, err
 	}
+	if p.currentToken().kind == Comma {
+		expr, err = p.parseTupleLiteral(expr)
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+	}
 
-	return &ReturnNode{expr: expr}, nil
+	return &ReturnNode{token: returnToken, expr: expr}, nil
 }
 
 func (p *Parser) parseFail() (Node, error) {
@@ -811,8 +1856,29 @@ func (p *Parser) parseFail() (Node, error) {
 	return &FailNode{expr: expr}, nil
 }
 
+func (p *Parser) parseYield() (Node, error) {
+
+	yieldToken, err := p.expectToken(Keyword) // yield
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+
+	expr, err := p.parseExpr()
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	if p.currentToken().kind == Comma {
+		expr, err = p.parseTupleLiteral(expr)
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+	}
+
+	return &YieldNode{token: yieldToken, expr: expr}, nil
+}
+
 func (p *Parser) parseIfStatement() (Node, error) {
-	_, err := p.expectToken(Keyword) // if
+	ifToken, err := p.expectToken(Keyword) // if
 	if err != nil {
 		return &NoOpNode{}, err
 	}
@@ -840,10 +1906,58 @@ func (p *Parser) parseIfStatement() (Node, error) {
 		if err != nil {
 			return &NoOpNode{}, err
 		}
-		return &IfNode{comp: comp, body: body, elseBody: elseBody}, nil
+		return &IfNode{token: ifToken, comp: comp, body: body, elseBody: elseBody}, nil
+	}
+
+	return &IfNode{token: ifToken, comp: comp, body: body, elseBody: &NoOpNode{}}, nil
+}
+
+// Parses an if used as a value, eg. `label = if n > 10 { "big" } else { "small" }`.
+// The else branch is required, and each branch holds a single expression.
+func (p *Parser) parseIfExpression() (Node, error) {
+	ifToken := p.consumeToken() // if
+	comp, err := p.parseExpr()
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	body, bodyScope, err := p.parseIfExpressionBranch()
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+
+	if p.currentToken().kind != Keyword || p.currentToken().str != "else" {
+		return &NoOpNode{}, p.parseError("an if used as a value must have an else branch", p.currentToken())
+	}
+	p.consumeToken() // else
+
+	node := &IfExprNode{token: ifToken, comp: comp, body: body, bodyScope: bodyScope}
+	if p.currentToken().kind == Keyword && p.currentToken().str == "if" {
+		node.elseBody, err = p.parseIfExpression()
+	} else {
+		node.elseBody, node.elseScope, err = p.parseIfExpressionBranch()
+	}
+	if err != nil {
+		return &NoOpNode{}, err
 	}
+	return node, nil
+}
 
-	return &IfNode{comp: comp, body: body, elseBody: &NoOpNode{}}, nil
+func (p *Parser) parseIfExpressionBranch() (Node, *Scope, error) {
+	_, err := p.expectToken(OpenCurly)
+	if err != nil {
+		return &NoOpNode{}, nil, err
+	}
+	p.newScope(nil, NoReturn{}, false)
+	defer p.leaveScope()
+	expr, err := p.parseExpr()
+	if err != nil {
+		return &NoOpNode{}, nil, err
+	}
+	if p.currentToken().kind != CloseCurly {
+		return &NoOpNode{}, nil, p.parseError("the branches of an if used as a value must be a single expression", p.currentToken())
+	}
+	p.consumeToken() // }
+	return expr, p.currentScope, nil
 }
 
 func (p *Parser) parseIterator() (Node, error) {
@@ -852,16 +1966,20 @@ func (p *Parser) parseIterator() (Node, error) {
 		return &NoOpNode{}, err
 	}
 	switch p.currentToken().kind {
-	case Range:
+	case Range, RangeInclusive:
 		rangeNode, err := p.parseRange(firstExpr)
 		if err != nil {
 			return &NoOpNode{}, err
 		}
 		return rangeNode, nil
 
+	case OpenCurly:
+		// Condition of a `for cond { }` loop
+		return firstExpr, nil
+
 	default:
 		switch firstExpr.(type) {
-		case *VarNode, *SliceLiteralNode:
+		case *VarNode, *SliceLiteralNode, *MapLiteralNode, *StringLiteralNode:
 			return firstExpr, nil
 		default:
 			panic("Non-supported iterator...")
@@ -869,8 +1987,78 @@ func (p *Parser) parseIterator() (Node, error) {
 	}
 }
 
+// Parses `while cond { }` and the infinite `loop { }`
+func (p *Parser) parseWhileLoop() (Node, error) {
+	token := p.consumeToken() // while or loop
+
+	var condition Node = &NoOpNode{}
+	if token.str == "while" {
+		var err error
+		condition, err = p.parseExpr()
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+	}
+
+	body, err := p.parseCompoundStatement(nil, NoReturn{}, false)
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	return &WhileNode{token: token, condition: condition, body: body}, nil
+}
+
+// Parses a labelled loop, eg. `outer: for rows -> row { }`, which `break outer` and `continue outer` can target
+func (p *Parser) parseLabelledLoop() (Node, error) {
+	labelToken := p.consumeToken()
+	p.consumeToken() // :
+	for _, label := range p.loopLabels {
+		if label.name == labelToken.str {
+			return &NoOpNode{}, p.parseError(fmt.Sprintf("loop label %q is already in use", labelToken.str), labelToken)
+		}
+	}
+
+	p.labelCount++
+	label := &LoopLabel{name: labelToken.str, id: p.labelCount}
+	p.loopLabels = append(p.loopLabels, label)
+	node, err := p.parseStatement()
+	p.loopLabels = p.loopLabels[:len(p.loopLabels)-1]
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+
+	switch n := node.(type) {
+	case *ForeachNode:
+		n.label = label
+		return n, nil
+	case *WhileNode:
+		n.label = label
+		return n, nil
+	case *FunctionCallNode:
+		if n.generatorBody != nil {
+			n.generatorLabel = label
+			return n, nil
+		}
+	}
+	return &NoOpNode{}, p.parseError(fmt.Sprintf("label %q must be followed by a loop", labelToken.str), labelToken)
+}
+
+// Parses the optional label of `break` and `continue`, which has to be on the same line
+func (p *Parser) parseLabelReference(keyword Token) (*LoopLabel, error) {
+	if p.currentToken().kind != Identifier || p.currentToken().line != keyword.line {
+		return nil, nil
+	}
+	labelToken := p.consumeToken()
+	for _, label := range p.loopLabels {
+		if label.name == labelToken.str {
+			label.used = true
+			return label, nil
+		}
+	}
+	return nil, p.parseError(fmt.Sprintf("unknown loop label %q", labelToken.str), labelToken)
+}
+
 func (p *Parser) parseForLoop() (Node, error) {
-	_, err := p.expectToken(Keyword) // for
+	forToken, err := p.expectToken(Keyword) // for
 	if err != nil {
 		return &NoOpNode{}, err
 	}
@@ -881,17 +2069,37 @@ func (p *Parser) parseForLoop() (Node, error) {
 	}
 	_, isRange := iterator.(*RangeNode)
 
+	// Condition-driven loop: `for i < 10 { }`
+	if !isRange && p.currentToken().kind == OpenCurly {
+		body, err := p.parseCompoundStatement(nil, NoReturn{}, false)
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		return &WhileNode{token: forToken, condition: iterator, body: body}, nil
+	}
+
 	_, err = p.expectToken(RightArrow) // ->
 	if err != nil {
 		return &NoOpNode{}, err
 	}
 
-	variable, err := p.parseVar(false)
-	if err != nil {
-		return &NoOpNode{}, err
+	var variable Node
+	var destructure []VarNode
+	var forParams []ParameterNode
+	if p.currentToken().kind == OpenParen {
+		destructure, err = p.parseDestructureTargets()
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		variable, forParams = destructureParameters(destructure)
+	} else {
+		variable, err = p.parseVar(false)
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+		controlVariable := &ParameterNode{name: variable.(*VarNode).token.str, typ: TypeUndetermined{}}
+		forParams = []ParameterNode{*controlVariable}
 	}
-	controlVariable := &ParameterNode{name: variable.(*VarNode).token.str, typ: TypeUndetermined{}}
-	forParams := []ParameterNode{*controlVariable}
 
 	// Parse optional index variable
 	var idxVariable Node
@@ -915,9 +2123,9 @@ func (p *Parser) parseForLoop() (Node, error) {
 	variableNode, _ := variable.(*VarNode)
 	if hasIdx {
 		idxVariableNode, _ := idxVariable.(*VarNode)
-		return &ForeachNode{iterator: iterator, variable: *variableNode, idxVariable: *idxVariableNode, body: body, hasIdx: hasIdx}, nil
+		return &ForeachNode{iterator: iterator, variable: *variableNode, idxVariable: *idxVariableNode, body: body, hasIdx: hasIdx, destructure: destructure}, nil
 	} else {
-		return &ForeachNode{iterator: iterator, variable: *variableNode, idxVariable: VarNode{}, body: body, hasIdx: hasIdx}, nil
+		return &ForeachNode{iterator: iterator, variable: *variableNode, idxVariable: VarNode{}, body: body, hasIdx: hasIdx, destructure: destructure}, nil
 	}
 }
 
@@ -925,8 +2133,11 @@ func (p *Parser) parseStatement() (Node, error) {
 	switch p.currentToken().kind {
 
 	case Identifier:
+		if p.atMatch() {
+			return p.parseMatch(false)
+		}
 		switch p.peek(1).kind {
-		case Assign:
+		case Assign, OpenBracket, PlusAssign, MinusAssign, MultAssign, DivAssign, ModuloAssign:
 			node, err := p.parseAssign(false)
 			if err != nil {
 				return &NoOpNode{}, err
@@ -938,19 +2149,50 @@ func (p *Parser) parseStatement() (Node, error) {
 				return &NoOpNode{}, err
 			}
 			return node, nil
+		case Comma:
+			node, err := p.parseDestructure()
+			if err != nil {
+				return &NoOpNode{}, err
+			}
+			return node, nil
+		case Colon:
+			node, err := p.parseLabelledLoop()
+			if err != nil {
+				return &NoOpNode{}, err
+			}
+			return node, nil
 		case Period: // FIXME: This was added to allow chained function calls as statements, eg `a.append(1)`. Is it correct?
 			node, err := p.parseExpr()
 			if err != nil {
 				return &NoOpNode{}, err
 			}
+
+			// Field assignment, eg. `row.name = "x"`
+			if field, isField := node.(*FieldAccessNode); isField && p.currentToken().kind == Assign {
+				token := p.consumeToken()
+				right, err := p.parseExpr()
+				if err != nil {
+					return &NoOpNode{}, err
+				}
+				return &AssignNode{left: field, token: token, right: right}, nil
+			}
+			if field, isField := node.(*FieldAccessNode); isField && isCompoundAssignOperator(p.currentToken().kind) {
+				return p.parseCompoundAssign(field)
+			}
 			return node, nil
 		case PlusPlus:
+			if p.isConstant(p.currentToken().str) {
+				return &NoOpNode{}, p.parseError(fmt.Sprintf("cannot modify constant %q", p.currentToken().str), p.currentToken())
+			}
 			varNode, err := p.parseVar(true)
 			if err != nil {
 				return &NoOpNode{}, err
 			}
 			return &IncNode{varName: varNode.(*VarNode).token.str, token: p.consumeToken()}, nil
 		case MinusMinus:
+			if p.isConstant(p.currentToken().str) {
+				return &NoOpNode{}, p.parseError(fmt.Sprintf("cannot modify constant %q", p.currentToken().str), p.currentToken())
+			}
 			varNode, err := p.parseVar(true)
 			if err != nil {
 				return &NoOpNode{}, err
@@ -964,10 +2206,15 @@ func (p *Parser) parseStatement() (Node, error) {
 	case Keyword:
 		switch p.currentToken().str {
 		case "fn":
+			nameToken := p.peek(1)
 			node, err := p.parseFunction()
 			if err != nil {
 				return &NoOpNode{}, err
 			}
+			// Nested functions become Go closures, which cannot have type parameters
+			if len(node.(*FunctionNode).typeParams) > 0 {
+				return &NoOpNode{}, p.parseError(fmt.Sprintf("nested function %q cannot have type parameters", nameToken.str), nameToken)
+			}
 			return node, nil
 		case "print":
 			node, err := p.parseFunctionCall(nil)
@@ -993,6 +2240,12 @@ func (p *Parser) parseStatement() (Node, error) {
 				return &NoOpNode{}, err
 			}
 			return node, nil
+		case "while", "loop":
+			node, err := p.parseWhileLoop()
+			if err != nil {
+				return &NoOpNode{}, err
+			}
+			return node, nil
 		case "true", "false":
 			node, err := p.parsePrimary()
 			if err != nil {
@@ -1006,12 +2259,28 @@ func (p *Parser) parseStatement() (Node, error) {
 				return &NoOpNode{}, err
 			}
 			return node, nil
+		case "yield":
+			node, err := p.parseYield()
+			if err != nil {
+				return &NoOpNode{}, err
+			}
+			return node, nil
 		case "continue":
-			p.consumeToken()
-			return &ContinueNode{}, nil
+			token := p.consumeToken()
+			label, err := p.parseLabelReference(token)
+			if err != nil {
+				return &NoOpNode{}, err
+			}
+			return &ContinueNode{token: token, label: label}, nil
+		case "record", "const", "import":
+			return &NoOpNode{}, p.parseError(fmt.Sprintf("%s declarations are only allowed at the top level", p.currentToken().str), p.currentToken())
 		case "break":
-			p.consumeToken()
-			return &BreakNode{}, nil
+			token := p.consumeToken()
+			label, err := p.parseLabelReference(token)
+			if err != nil {
+				return &NoOpNode{}, err
+			}
+			return &BreakNode{token: token, label: label}, nil
 		default:
 			panic("Unimplemented keyword")
 		}
@@ -1045,11 +2314,20 @@ func (p *Parser) parseVar(checkIfDeclared bool) (Node, error) {
 		}
 	}
 
-	// Indexing (eg. a[10])
-	if p.currentToken().kind == OpenBracket {
+	// Indexing (eg. a[10]), ranges may be open-ended: a[..10] and a[10..]. Elements of
+	// nested containers are indexed in turn, eg. grid[1][2]
+	var indexed *IndexedVarNode
+	for p.currentToken().kind == OpenBracket {
 		p.consumeToken() // [
-		indexNode, err := p.parseExpr()
-		if p.currentToken().kind == Range {
+		var indexNode Node = &NoOpNode{}
+		var err error
+		if !isRangeOperator(p.currentToken().kind) {
+			indexNode, err = p.parseExpr()
+			if err != nil {
+				return &NoOpNode{}, err
+			}
+		}
+		if isRangeOperator(p.currentToken().kind) {
 			indexNode, err = p.parseRange(indexNode)
 			if err != nil {
 				return &NoOpNode{}, err
@@ -1059,21 +2337,82 @@ func (p *Parser) parseVar(checkIfDeclared bool) (Node, error) {
 		if err != nil {
 			return &NoOpNode{}, err
 		}
-		return &IndexedVarNode{token: token, index: indexNode}, nil
+		indexed = &IndexedVarNode{token: token, index: indexNode, container: indexed}
+	}
+	if indexed != nil {
+		return indexed, nil
 	}
 	return &VarNode{token: token}, nil
 
 }
 
+func isCompoundAssignOperator(kind TokenKind) bool {
+	switch kind {
+	case PlusAssign, MinusAssign, MultAssign, DivAssign, ModuloAssign:
+		return true
+	}
+	return false
+}
+
+// Parses compound assignments such as `total += x`. The target must already exist.
+func (p *Parser) parseCompoundAssign(left Node) (Node, error) {
+	switch lhs := left.(type) {
+	case *VarNode, *IndexedVarNode:
+		var token Token
+		if indexed, isIndexed := lhs.(*IndexedVarNode); isIndexed {
+			token = indexed.token
+			if _, isRange := indexed.index.(*RangeNode); isRange {
+				return &NoOpNode{}, p.parseError(fmt.Sprintf("cannot use %s on a range of %q", p.currentToken().str, token.str), p.currentToken())
+			}
+		} else {
+			token = lhs.(*VarNode).token
+		}
+		if p.isConstant(token.str) {
+			return &NoOpNode{}, p.parseError(fmt.Sprintf("cannot modify constant %q", token.str), token)
+		}
+		if isDeclared := p.validateVariable(token.str); !isDeclared {
+			return &NoOpNode{}, p.parseError(fmt.Sprintf("use of undeclared variable: %q", token.str), token)
+		}
+	}
+
+	opToken := p.consumeToken()
+	right, err := p.parseExpr()
+	if err != nil {
+		return &NoOpNode{}, err
+	}
+	return &CompoundAssignNode{token: opToken, left: left, right: right}, nil
+}
+
 func (p *Parser) parseAssign(asExpr bool) (Node, error) {
 	left, err := p.parseVar(false)
 	if err != nil {
 		return &NoOpNode{}, err
 	}
-	lhsName := left.(*VarNode).token.str
-	_, exists := p.currentScope.lookupSymbol(lhsName)
-	if !exists {
-		_ = p.createVariableInCurrentScope(lhsName, TypeUndetermined{})
+
+	if !asExpr && isCompoundAssignOperator(p.currentToken().kind) {
+		return p.parseCompoundAssign(left)
+	}
+
+	var exists bool
+	switch lhs := left.(type) {
+	case *IndexedVarNode:
+		if p.isConstant(lhs.token.str) {
+			return &NoOpNode{}, p.parseError(fmt.Sprintf("cannot assign to constant %q", lhs.token.str), lhs.token)
+		}
+
+		// Element assignment, eg. `a[i] = x`. The container itself must already exist.
+		if isDeclared := p.validateVariable(lhs.token.str); !isDeclared {
+			return &NoOpNode{}, p.parseError(fmt.Sprintf("use of undeclared variable: %q", lhs.token.str), lhs.token)
+		}
+		exists = true
+	case *VarNode:
+		if p.isConstant(lhs.token.str) {
+			return &NoOpNode{}, p.parseError(fmt.Sprintf("cannot assign to constant %q", lhs.token.str), lhs.token)
+		}
+		_, exists = p.currentScope.lookupSymbol(lhs.token.str)
+		if !exists {
+			_ = p.createVariableInCurrentScope(lhs.token.str, TypeUndetermined{})
+		}
 	}
 	token, err := p.expectToken(Assign)
 	if err != nil {
@@ -1092,7 +2431,7 @@ func (p *Parser) parseAssign(asExpr bool) (Node, error) {
 		if err != nil {
 			return &NoOpNode{}, err
 		}
-		if p.currentToken().kind == Range {
+		if isRangeOperator(p.currentToken().kind) {
 			rangeNode, err := p.parseRange(right)
 			if err != nil {
 				return &NoOpNode{}, err
@@ -1103,11 +2442,10 @@ func (p *Parser) parseAssign(asExpr bool) (Node, error) {
 	return &AssignNode{left: left, token: token, right: right, declaration: !exists, expression: asExpr}, nil
 }
 
+// Parses the rest of a range after its start, eg. `..10`, `..=10` or `..100 by 5`
 func (p *Parser) parseRange(startNode Node) (Node, error) {
-	rangeToken, err := p.expectToken(Range)
-	if err != nil {
-		panic("UNREACHABLE")
-	}
+	rangeToken := p.consumeToken() // .. or ..=
+	var err error
 	var end Node = &NoOpNode{}
 	if p.currentToken().kind != CloseBracket {
 		end, err = p.parseExpr()
@@ -1115,20 +2453,173 @@ func (p *Parser) parseRange(startNode Node) (Node, error) {
 			return &NoOpNode{}, err
 		}
 	}
-	return &RangeNode{token: rangeToken, from: startNode, to: end, step: 1}, nil
+	// `by` is not a keyword, so that it can still be used as a name
+	var step Node = &NoOpNode{}
+	if p.currentToken().kind == Identifier && p.currentToken().str == "by" {
+		p.consumeToken() // by
+		step, err = p.parseExpr()
+		if err != nil {
+			return &NoOpNode{}, err
+		}
+	}
+	return &RangeNode{token: rangeToken, from: startNode, to: end, step: step, inclusive: rangeToken.kind == RangeInclusive}, nil
 }
 
-func Parse(tokens []Token, fileNames []string) (Node, error) {
-	rootScope := newScope(nil, nil, NoReturn{}, false)
-	parser := Parser{tokens, 0, 0, rootScope, make(map[string]bool), fileNames}
+func isRangeOperator(kind TokenKind) bool {
+	return kind == Range || kind == RangeInclusive
+}
+
+// Parses `import "path"`. The path is relative to the importing file, and the imported
+// declarations are merged into the program. Each file is only parsed once.
+func (p *Parser) parseImport(program *ProgramNode) error {
+	_, err := p.expectToken(Keyword) // import
+	if err != nil {
+		return err
+	}
+	pathToken, err := p.expectToken(StringLiteral)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(filepath.Dir(p.modules.fileNames[pathToken.file]), pathToken.str)
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return p.parseError(fmt.Sprintf("cannot resolve import path %q: %v", pathToken.str, err), pathToken)
+	}
+
+	for i, fileNum := range p.modules.loading {
+		loadingPath, _ := filepath.Abs(p.modules.fileNames[fileNum])
+		if loadingPath == absPath {
+			var cycle []string
+			for _, cycleFileNum := range p.modules.loading[i:] {
+				cycle = append(cycle, p.modules.fileNames[cycleFileNum])
+			}
+			cycle = append(cycle, path)
+			return p.parseError(fmt.Sprintf("import cycle: %s", strings.Join(cycle, " -> ")), pathToken)
+		}
+	}
+	if p.modules.loaded[absPath] {
+		return nil
+	}
+
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return p.parseError(fmt.Sprintf("cannot import %q: %v", pathToken.str, err), pathToken)
+	}
+	fileNum := len(p.modules.fileNames)
+	p.modules.fileNames = append(p.modules.fileNames, path)
+	tokens, err := Tokenize(string(code)+"\n", fileNum)
+	if err != nil {
+		return p.parseError(fmt.Sprintf("cannot import %q: %v", pathToken.str, err), pathToken)
+	}
+
+	importParser := Parser{tokens, 0, 0, p.currentScope, p.imports, p.modules, nil, 0, nil, nil}
+	p.modules.loading = append(p.modules.loading, fileNum)
+	err = importParser.parseModule(program)
+	p.modules.loading = p.modules.loading[:len(p.modules.loading)-1]
+	p.modules.loaded[absPath] = true
+	return err
+}
+
+// Parses the top-level declarations of a file into the program
+func (p *Parser) parseModule(program *ProgramNode) error {
+	p.collectFunctionNames()
+	for p.currentToken().kind != Eof {
+		token := p.currentToken()
+		if token.kind == Keyword && token.str == "import" {
+			err := p.parseImport(program)
+			if err != nil {
+				return err
+			}
+			continue
+		}
 
-	var functions []Node
-	for parser.currentToken().kind != Eof {
-		fn, err := parser.parseFunction()
+		if token.kind == Keyword && token.str == "record" {
+			record, err := p.parseRecord()
+			if err != nil {
+				return err
+			}
+			program.records = append(program.records, record)
+			continue
+		}
+
+		if token.kind == Keyword && token.str == "enum" {
+			enum, err := p.parseEnum()
+			if err != nil {
+				return err
+			}
+			program.enums = append(program.enums, enum)
+			continue
+		}
+
+		// Constants and module-level variables
+		if token.kind == Keyword && token.str == "const" {
+			constant, err := p.parseConst()
+			if err != nil {
+				return err
+			}
+			program.globals = append(program.globals, constant)
+			continue
+		}
+		// `strict` on a line of its own disables implicit type conversions in the file.
+		// It is not a keyword, so that it can still be used as a name.
+		if token.kind == Identifier && token.str == "strict" && p.peek(1).line != token.line {
+			p.consumeToken()
+			program.strictFiles[token.file] = true
+			continue
+		}
+
+		if token.kind == Identifier {
+			if p.peek(1).kind != Assign {
+				return p.parseError(fmt.Sprintf("expected assignment to module-level variable %q", token.str), p.peek(1))
+			}
+			global, err := p.parseAssign(false)
+			if err != nil {
+				return err
+			}
+			program.globals = append(program.globals, global)
+			continue
+		}
+
+		fn, err := p.parseFunction()
 		if err != nil {
-			return &ProgramNode{}, err
+			return err
+		}
+		program.functions = append(program.functions, fn)
+	}
+	return nil
+}
+
+// Finds the names of the top-level functions and enums in a file before it is parsed
+func (p *Parser) collectFunctionNames() {
+	depth := 0
+	for i, token := range p.tokens[:len(p.tokens)-1] {
+		switch token.kind {
+		case OpenCurly:
+			depth++
+		case CloseCurly:
+			depth--
+		case Keyword:
+			if token.str == "fn" && depth == 0 && p.tokens[i+1].kind == Identifier {
+				p.modules.functions[p.tokens[i+1].str] = true
+			}
+			if token.str == "enum" && depth == 0 && p.tokens[i+1].kind == Identifier {
+				p.modules.enums[p.tokens[i+1].str] = true
+			}
 		}
-		functions = append(functions, fn)
 	}
-	return &ProgramNode{functions: functions, imports: parser.imports}, nil
+}
+
+func Parse(tokens []Token, fileNames []string) (Node, error) {
+	rootScope := newScope(nil, nil, NoReturn{}, false)
+	modules := &Modules{fileNames: fileNames, loaded: make(map[string]bool), loading: []int{0}, functions: make(map[string]bool), enums: make(map[string]bool)}
+	parser := Parser{tokens, 0, 0, rootScope, make(map[string]bool), modules, nil, 0, nil, nil}
+
+	program := &ProgramNode{imports: parser.imports, scope: rootScope, strictFiles: make(map[int]bool)}
+	err := parser.parseModule(program)
+	if err != nil {
+		return &ProgramNode{}, err
+	}
+	program.fileNames = modules.fileNames
+	return program, nil
 }
@@ -1,5 +1,10 @@
 package parser
 
+import (
+	"fmt"
+	"strings"
+)
+
 func preludeCode(name string) string {
 	switch name {
 	case "stringToFloat":
@@ -27,14 +32,60 @@ func stringToInt(s string) int {
 	case "intToString":
 		return ""
 
-	case "createRange":
+	case "parseInt":
+		return `
+func ___parseInt(s string) (int, error) {
+    i, err := strconv.Atoi(s)
+    if err != nil {
+        return 0, fmt.Errorf("string %q cannot be converted to integer", s)
+    }
+    return i, nil
+}
+`
+	case "parseFloat":
 		return `
-func ___createRange(from int, to int) []int {
-    a := make([]int, to-from+1)
-    for i := range a {
-        a[i] = from + i
+func ___parseFloat(s string) (float64, error) {
+    f, err := strconv.ParseFloat(s, 64)
+    if err != nil {
+        return 0, fmt.Errorf("string %q cannot be converted to float", s)
     }
-    return a
+    return f, nil
+}
+`
+
+	case "rangeIter":
+		return `
+// Without a step, ranges count down when from is larger than to, eg. 10..1. With a step,
+// its sign gives the direction, so 1..n by 1 is empty when n is smaller than 1.
+func ___rangeIter(from int, to int, step int, stepped bool, inclusive bool) iter.Seq[int] {
+    if stepped && step == 0 {
+        fmt.Fprintf(os.Stderr, "Runtime error: range step cannot be zero")
+        os.Exit(99)
+    }
+    if !stepped && from > to {
+        step = -1
+    }
+    return func(yield func(int) bool) {
+        if step > 0 {
+            for i := from; i < to || (inclusive && i == to); i += step {
+                if !yield(i) {
+                    return
+                }
+            }
+        } else {
+            for i := from; i > to || (inclusive && i == to); i += step {
+                if !yield(i) {
+                    return
+                }
+            }
+        }
+    }
+}
+`
+	case "createRange":
+		return `
+func ___createRange(from int, to int, step int, stepped bool, inclusive bool) []int {
+    return slices.Collect(___rangeIter(from, to, step, stepped, inclusive))
 }
 `
 	case "joinIntSlice":
@@ -107,17 +158,150 @@ func ___regexFind(haystack string, regexpStr string) []string {
 
 	case "slurpFile":
 		return `
-func ___slurpFile(path string) string {
+func ___slurpFile(path string) (string, error) {
     b, err := os.ReadFile(path)
     if err != nil {
-        panic("Cannot read file")
+        return "", ___fileError(path, err)
+    }
+    return string(b), nil
+}
+`
+	case "openFile":
+		return `
+func ___openFile(path string) (*os.File, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, ___fileError(path, err)
+    }
+    return file, nil
+}
+`
+	case "fileError":
+		return `
+// Reports the path and the OS error, eg. "no such file or directory"
+func ___fileError(path string, err error) error {
+    if pathErr, isPathErr := err.(*os.PathError); isPathErr {
+        err = pathErr.Err
+    }
+    return fmt.Errorf("cannot read file %q: %w", path, err)
+}
+`
+	case "newScanner":
+		return `
+// Lines may be up to 1GB long, instead of bufio's default of 64KB
+func ___newScanner(file *os.File) *bufio.Scanner {
+    scanner := bufio.NewScanner(file)
+    scanner.Buffer(make([]byte, 64*1024), 1024*1024*1024)
+    return scanner
+}
+`
+	case "scanError":
+		return `
+// Returns the error that ended reading a file early, if any, eg. a line that is too long
+func ___scanError(file *os.File, scanner *bufio.Scanner) error {
+    if err := scanner.Err(); err != nil {
+        return ___fileError(file.Name(), err)
+    }
+    return nil
+}
+`
+	case "readLines":
+		return `
+func ___readLines(path string, chomp bool) ([]string, error) {
+    file, err := ___openFile(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    lines := []string{}
+    scanner := ___newScanner(file)
+    for scanner.Scan() {
+        line := scanner.Text()
+        if !chomp {
+            line += "\n"
+        }
+        lines = append(lines, line)
+    }
+    return lines, ___scanError(file, scanner)
+}
+`
+	case "readSeq":
+		return `
+// Yields the lines of a file one at a time, a failure ends the lines with an error
+func ___readSeq(path string, chomp bool) iter.Seq2[string, error] {
+    return func(yield func(string, error) bool) {
+        file, err := ___openFile(path)
+        if err != nil {
+            yield("", err)
+            return
+        }
+        defer file.Close()
+        scanner := ___newScanner(file)
+        for scanner.Scan() {
+            line := scanner.Text()
+            if !chomp {
+                line += "\n"
+            }
+            if !yield(line, nil) {
+                return
+            }
+        }
+        if err := ___scanError(file, scanner); err != nil {
+            yield("", err)
+        }
+    }
+}
+`
+	case "readFieldsSeq":
+		return `
+func ___readFieldsSeq(path string, chomp bool, sep string) iter.Seq2[[]string, error] {
+    return func(yield func([]string, error) bool) {
+        for line, err := range ___readSeq(path, chomp) {
+            if err != nil {
+                yield(nil, err)
+                return
+            }
+            if !yield(strings.Split(line, sep), nil) {
+                return
+            }
+        }
+    }
+}
+`
+	case "seqErrors":
+		return `
+// Ends a fallible generator at its first error, which is stored in err
+func ___seqErrors[T any](seq iter.Seq2[T, error], err *error) iter.Seq[T] {
+    return func(yield func(T) bool) {
+        for v, e := range seq {
+            if e != nil {
+                *err = e
+                return
+            }
+            if !yield(v) {
+                return
+            }
+        }
+    }
+}
+`
+	case "readFields":
+		return `
+func ___readFields(path string, chomp bool, sep string) ([][]string, error) {
+    lines, err := ___readLines(path, chomp)
+    if err != nil {
+        return nil, err
     }
-    return string(b)
+    fields := make([][]string, len(lines))
+    for i, line := range lines {
+        fields[i] = strings.Split(line, sep)
+    }
+    return fields, nil
 }
 `
 	case "setContains":
 		return `
-func ___setContains[T comparable](haystack map[T]struct{}, needle T) bool {
+func ___setContains[T comparable, V any](haystack map[T]V, needle T) bool {
     _, found := haystack[needle]
     return found
 }
@@ -146,25 +330,387 @@ func ___sliceToSet[T comparable](s []T) map[T]struct{} {
     return out
 }
 `
+	case "mapGet":
+		return `
+func ___mapGet[K comparable, V any](m map[K]V, key K) V {
+    value, found := m[key]
+    if !found {
+        fmt.Fprintf(os.Stderr, "Runtime error: key %#v not found in map", key)
+        os.Exit(99)
+    }
+    return value
+}
+`
+	case "mapGetDefault":
+		return `
+func ___mapGetDefault[K comparable, V any](m map[K]V, key K, defaultValue V) V {
+    value, found := m[key]
+    if !found {
+        return defaultValue
+    }
+    return value
+}
+`
+	case "sortedValues":
+		return `
+func ___sortedValues[K cmp.Ordered, V any](m map[K]V) []V {
+    values := make([]V, 0, len(m))
+    for _, k := range slices.Sorted(maps.Keys(m)) {
+        values = append(values, m[k])
+    }
+    return values
+}
+`
+	case "sortedItems":
+		return `
+func ___sortedItems[K cmp.Ordered, V any](m map[K]V) iter.Seq2[K, V] {
+    return func(yield func(K, V) bool) {
+        for _, k := range slices.Sorted(maps.Keys(m)) {
+            if !yield(k, m[k]) {
+                return
+            }
+        }
+    }
+}
+`
+	case "convertSlice":
+		return `
+func ___convertSlice[F, T any](s []F, convert func(F) T) []T {
+    result := make([]T, len(s))
+    for i, v := range s {
+        result[i] = convert(v)
+    }
+    return result
+}
+`
+	case "convertSet":
+		return `
+func ___convertSet[F, T comparable](s map[F]struct{}, convert func(F) T) map[T]struct{} {
+    result := make(map[T]struct{}, len(s))
+    for v := range s {
+        result[convert(v)] = struct{}{}
+    }
+    return result
+}
+`
+	case "convertMap":
+		return `
+func ___convertMap[FK, TK comparable, FV, TV any](m map[FK]FV, convertKey func(FK) TK, convertValue func(FV) TV) map[TK]TV {
+    result := make(map[TK]TV, len(m))
+    for k, v := range m {
+        result[convertKey(k)] = convertValue(v)
+    }
+    return result
+}
+`
+	case "intPower":
+		return `
+func ___intPower(base int, exponent int, location string) int {
+    if exponent < 0 {
+        fmt.Fprintf(os.Stderr, "Runtime error: %s: negative exponent %d in int power", location, exponent)
+        os.Exit(99)
+    }
+    result := 1
+    for exponent > 0 {
+        if exponent&1 == 1 {
+            result *= base
+        }
+        base *= base
+        exponent >>= 1
+    }
+    return result
+}
+`
+	case "checkIndex":
+		return `
+func ___checkIndex(length int, i int, location string) int {
+    index := i
+    if index < 0 {
+        index += length
+    }
+    if index < 0 || index >= length {
+        fmt.Fprintf(os.Stderr, "Runtime error: %s: index %d out of range for length %d", location, i, length)
+        os.Exit(99)
+    }
+    return index
+}
+`
+	case "checkRange":
+		return `
+func ___checkRange(length int, from int, to int, inclusive bool, location string) (int, int) {
+    start, end := from, to
+    if start < 0 {
+        start += length
+    }
+    if end < 0 {
+        end += length
+    }
+    if inclusive {
+        end++
+    }
+    if start < 0 || end > length || start > end {
+        operator := ".."
+        if inclusive {
+            operator = "..="
+        }
+        fmt.Fprintf(os.Stderr, "Runtime error: %s: range %d%s%d out of range for length %d", location, from, operator, to, length)
+        os.Exit(99)
+    }
+    return start, end
+}
+`
+	case "sliceRange":
+		return `
+func ___sliceRange[T any](s []T, from int, to int, inclusive bool, location string) []T {
+    start, end := ___checkRange(len(s), from, to, inclusive, location)
+    return s[start:end]
+}
+`
+	case "stringRange":
+		return `
+func ___stringRange(s string, from int, to int, inclusive bool, location string) string {
+    runes := []rune(s)
+    start, end := ___checkRange(len(runes), from, to, inclusive, location)
+    return string(runes[start:end])
+}
+`
+	case "stringIndex":
+		return `
+func ___stringIndex(s string, i int, location string) string {
+    runes := []rune(s)
+    return string(runes[___checkIndex(len(runes), i, location)])
+}
+`
+	case "stringBytes":
+		return `
+func ___stringBytes(s string) []int {
+    bytes := make([]int, len(s))
+    for i := range len(s) {
+        bytes[i] = int(s[i])
+    }
+    return bytes
+}
+`
+	case "replaceRange":
+		return `
+func ___replaceRange[T any](s []T, values []T, from int, to int, inclusive bool, location string) []T {
+    start, end := ___checkRange(len(s), from, to, inclusive, location)
+    return slices.Replace(s, start, end, values...)
+}
+`
+	case "mapSlice":
+		return `
+func ___mapSlice[T, R any](s []T, f func(T) R) []R {
+    result := make([]R, len(s))
+    for i, v := range s {
+        result[i] = f(v)
+    }
+    return result
+}
+`
+	case "filterSlice":
+		return `
+func ___filterSlice[T any](s []T, f func(T) bool) []T {
+    result := []T{}
+    for _, v := range s {
+        if f(v) {
+            result = append(result, v)
+        }
+    }
+    return result
+}
+`
+	case "filterSet":
+		return `
+func ___filterSet[T comparable](s map[T]struct{}, f func(T) bool) map[T]struct{} {
+    result := map[T]struct{}{}
+    for v := range s {
+        if f(v) {
+            result[v] = struct{}{}
+        }
+    }
+    return result
+}
+`
+	case "reduceSlice":
+		return `
+func ___reduceSlice[T, A any](s []T, f func(A, T) A, initial A) A {
+    result := initial
+    for _, v := range s {
+        result = f(result, v)
+    }
+    return result
+}
+`
+	case "anySlice":
+		return `
+func ___anySlice[T any](s []T, f func(T) bool) bool {
+    for _, v := range s {
+        if f(v) {
+            return true
+        }
+    }
+    return false
+}
+`
+	case "allSlice":
+		return `
+func ___allSlice[T any](s []T, f func(T) bool) bool {
+    for _, v := range s {
+        if !f(v) {
+            return false
+        }
+    }
+    return true
+}
+`
+	case "mapSeq":
+		return `
+func ___mapSeq[T, R any](seq iter.Seq[T], f func(T) R) iter.Seq[R] {
+    return func(yield func(R) bool) {
+        for v := range seq {
+            if !yield(f(v)) {
+                return
+            }
+        }
+    }
+}
+`
+	case "filterSeq":
+		return `
+func ___filterSeq[T any](seq iter.Seq[T], f func(T) bool) iter.Seq[T] {
+    return func(yield func(T) bool) {
+        for v := range seq {
+            if f(v) && !yield(v) {
+                return
+            }
+        }
+    }
+}
+`
+	case "reduceSeq":
+		return `
+func ___reduceSeq[T, A any](seq iter.Seq[T], f func(A, T) A, initial A) A {
+    result := initial
+    for v := range seq {
+        result = f(result, v)
+    }
+    return result
+}
+`
+	case "anySeq":
+		return `
+func ___anySeq[T any](seq iter.Seq[T], f func(T) bool) bool {
+    for v := range seq {
+        if f(v) {
+            return true
+        }
+    }
+    return false
+}
+`
+	case "allSeq":
+		return `
+func ___allSeq[T any](seq iter.Seq[T], f func(T) bool) bool {
+    for v := range seq {
+        if !f(v) {
+            return false
+        }
+    }
+    return true
+}
+`
+	case "sortBy":
+		return `
+// Stable, so elements with the same key keep their order
+func ___sortBy[T any, K cmp.Ordered](s []T, key func(T) K) []T {
+    sorted := slices.Clone(s)
+    slices.SortStableFunc(sorted, func(a, b T) int {
+        return cmp.Compare(key(a), key(b))
+    })
+    return sorted
+}
+`
+	case "tuples":
+		return tuplePrelude()
 	default:
 		panic("Unknown prelude")
 	}
 }
 
+// Generic structs for every supported tuple size, eg. `___Tuple2[T0, T1 any] struct{ V0 T0; V1 T1 }`
+func tuplePrelude() string {
+	var code strings.Builder
+	for size := 2; size <= maxTupleSize; size++ {
+		var typeParams, fields, formats, values []string
+		for i := range size {
+			typeParams = append(typeParams, fmt.Sprintf("T%d", i))
+			fields = append(fields, fmt.Sprintf("    V%d T%d", i, i))
+			formats = append(formats, "%v")
+			values = append(values, fmt.Sprintf("t.V%d", i))
+		}
+		fmt.Fprintf(&code, "\ntype ___Tuple%d[%s any] struct {\n%s\n}\n", size, strings.Join(typeParams, ", "), strings.Join(fields, "\n"))
+		fmt.Fprintf(&code, "\nfunc (t ___Tuple%d[%s]) String() string {\n    return fmt.Sprintf(\"(%s)\", %s)\n}\n", size, strings.Join(typeParams, ", "), strings.Join(formats, ", "), strings.Join(values, ", "))
+	}
+	return code.String()
+}
+
 func preludeImports(name string) []string {
 	switch name {
 	case "stringToInt", "stringToFloat":
 		return []string{"fmt", "strconv", "os"}
 	case "intToString":
 		return []string{"strconv"}
-	case "createRange", "setContains", "setUnion", "sliceToSet":
+	case "parseInt", "parseFloat":
+		return []string{"fmt", "strconv"}
+	case "rangeIter":
+		return []string{"fmt", "iter", "os"}
+	case "createRange":
+		return []string{"slices"}
+	case "setContains", "setUnion", "sliceToSet":
 		return []string{}
 	case "joinIntSlice", "joinFloatSlice":
 		return []string{"strings", "strconv"}
-	case "handleNonPropagatableError", "slurpFile":
+	case "handleNonPropagatableError":
 		return []string{"os"}
+	case "newScanner", "scanError":
+		return []string{"bufio", "os"}
+	case "slurpFile", "openFile", "fileError":
+		return []string{"fmt", "os"}
+	case "readLines":
+		return []string{"bufio"}
+	case "readFields":
+		return []string{"strings"}
+	case "readSeq", "seqErrors":
+		return []string{"iter"}
+	case "readFieldsSeq":
+		return []string{"iter", "strings"}
 	case "regexMatch", "regexCapture", "regexFind":
 		return []string{"regexp"}
+	case "mapGet":
+		return []string{"fmt", "os"}
+	case "mapGetDefault":
+		return []string{}
+	case "sortedValues":
+		return []string{"cmp", "maps", "slices"}
+	case "sortedItems":
+		return []string{"cmp", "iter", "maps", "slices"}
+	case "tuples":
+		return []string{"fmt"}
+	case "convertSlice", "convertSet", "convertMap":
+		return []string{}
+	case "checkIndex", "checkRange", "intPower":
+		return []string{"fmt", "os"}
+	case "sliceRange", "stringRange", "stringIndex", "stringBytes":
+		return []string{}
+	case "replaceRange":
+		return []string{"slices"}
+	case "mapSlice", "filterSlice", "filterSet", "reduceSlice", "anySlice", "allSlice":
+		return []string{}
+	case "mapSeq", "filterSeq", "reduceSeq", "anySeq", "allSeq":
+		return []string{"iter"}
+	case "sortBy":
+		return []string{"cmp", "slices"}
 	default:
 		panic("Unknown prelude")
 	}
@@ -2,8 +2,11 @@ package parser
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type Token struct {
@@ -21,6 +24,7 @@ type Tokenizer struct {
 	currentLine   int
 	currentColumn int
 	state         string
+	pending       []Token
 }
 
 func newTokenizer(sourceString string, fileNum int) Tokenizer {
@@ -35,29 +39,30 @@ func newTokenizer(sourceString string, fileNum int) Tokenizer {
 	return parser
 }
 
+// The source is UTF-8, so positions are byte offsets while skipping and columns count runes
 func (p *Tokenizer) currentRune() rune {
-	return rune(p.source[p.pos])
+	r, _ := utf8.DecodeRuneInString(p.source[p.pos:])
+	return r
 }
 
 func (p *Tokenizer) skip(n int) {
-	end := p.pos + n
-	for p.pos < end {
+	for range n {
 		if p.EOF() {
 			break
 		}
-		if p.currentRune() == '\n' {
+		r, size := utf8.DecodeRuneInString(p.source[p.pos:])
+		if r == '\n' {
 			p.currentColumn = 0
 			p.currentLine += 1
 		} else {
 			p.currentColumn += 1
 		}
-		p.pos++
+		p.pos += size
 	}
 }
 
 func (p *Tokenizer) revert(n int) {
-	end := p.pos - n
-	for p.pos > end {
+	for range n {
 		if p.pos == 0 {
 			break
 		}
@@ -67,7 +72,8 @@ func (p *Tokenizer) revert(n int) {
 		} else {
 			p.currentColumn -= 1
 		}
-		p.pos--
+		_, size := utf8.DecodeLastRuneInString(p.source[:p.pos])
+		p.pos -= size
 	}
 }
 
@@ -101,8 +107,7 @@ func (p *Tokenizer) consume() rune {
 
 func (p *Tokenizer) consumeMany(n int) string {
 	consumedString := ""
-	start := p.pos
-	for p.pos < start+n {
+	for range n {
 		consumedString += string(p.consume())
 	}
 	return consumedString
@@ -117,7 +122,19 @@ func (p *Tokenizer) consumeUntil(lastRune rune) string {
 }
 
 func (p *Tokenizer) peek(ahead int) rune {
-	return rune(p.source[p.pos+ahead])
+	pos := p.pos
+	for range ahead {
+		_, size := utf8.DecodeRuneInString(p.source[pos:])
+		pos += size
+	}
+	r, _ := utf8.DecodeRuneInString(p.source[pos:])
+	return r
+}
+
+var quantifierRegex = regexp.MustCompile(`^\{\d*,?\d*\}`)
+
+func (p *Tokenizer) atString(str string) bool {
+	return strings.HasPrefix(p.source[p.pos:], str)
 }
 
 func isAlNum(r rune) bool {
@@ -130,7 +147,7 @@ func (t *Tokenizer) consumeIdentifier() Token {
 		identifierString += string(t.consume())
 	}
 	switch identifierString {
-	case "fn", "if", "for", "in", "print", "return", "true", "false", "else", "fail", "continue", "break", "set":
+	case "fn", "if", "for", "in", "print", "return", "true", "false", "else", "fail", "continue", "break", "set", "record", "const", "import", "gen", "yield", "while", "loop", "enum":
 		return t.createTokenFromString(Keyword, identifierString)
 	default:
 		return t.createTokenFromString(Identifier, identifierString)
@@ -169,9 +186,177 @@ func (t *Tokenizer) consumeNumber() (Token, error) {
 	}
 }
 
+// Decodes an escape sequence starting at the current backslash
+func (t *Tokenizer) consumeEscape() (string, error) {
+	t.skip(1) // \
+	if t.EOF() {
+		return "", fmt.Errorf("Unterminated string literal")
+	}
+	r := t.consume()
+	switch r {
+	case 'n':
+		return "\n", nil
+	case 't':
+		return "\t", nil
+	case 'r':
+		return "\r", nil
+	case '\\', '"', '{', '}':
+		return string(r), nil
+	case 'u':
+		if t.currentRune() != '{' {
+			return "", fmt.Errorf("Invalid unicode escape, expected \\u{...}")
+		}
+		t.skip(1) // {
+		hex := t.consumeUntil('}')
+		t.skip(1) // }
+		codePoint, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || codePoint > unicode.MaxRune {
+			return "", fmt.Errorf("Invalid unicode escape: \\u{%s}", hex)
+		}
+		return string(rune(codePoint)), nil
+	default:
+		return "", fmt.Errorf("Invalid escape sequence: %s", strconv.Quote("\\"+string(r)))
+	}
+}
+
+// Consumes a raw string literal, in which nothing is escaped or interpolated
+func (t *Tokenizer) consumeRawString() (Token, error) {
+	t.skip(1) // `
+	rawString := t.consumeUntil('`')
+	if t.currentRune() != '`' {
+		return Token{}, fmt.Errorf("Unterminated raw string literal")
+	}
+	t.skip(1) // `
+	return t.createTokenFromString(StringLiteral, rawString), nil
+}
+
+// Returns the indentation common to all non-blank lines of a multi-line string starting
+// at the current position, including the line of the closing quotes.
+func (t *Tokenizer) multilineIndent() (int, error) {
+	indent := -1
+	for _, line := range strings.SplitAfter(t.source[t.pos:], "\n") {
+		content := strings.TrimLeft(line, " \t")
+		width := len(line) - len(content)
+		isClosing := strings.HasPrefix(content, `"""`)
+		if strings.TrimSpace(content) != "" && (indent == -1 || width < indent) {
+			indent = width
+		}
+		if isClosing || strings.Contains(content, `"""`) {
+			return indent, nil
+		}
+	}
+	return 0, fmt.Errorf("Unterminated multi-line string literal")
+}
+
+func (t *Tokenizer) consumeString() ([]Token, error) {
+	t.skip(1) // "
+	return t.consumeStringBody(`"`, 0)
+}
+
+// Consumes a triple-quoted string. The text starts on the line after the opening quotes,
+// the indentation common to all lines is stripped, and if the closing quotes are on a line
+// of their own the line break before them is not part of the string.
+func (t *Tokenizer) consumeMultilineString() ([]Token, error) {
+	t.skip(3) // """
+	for t.currentRune() == ' ' || t.currentRune() == '\t' {
+		t.skip(1)
+	}
+	if t.currentRune() != '\n' {
+		return nil, fmt.Errorf("Multi-line string must start on a new line after \"\"\"")
+	}
+	t.skip(1) // \n
+	indent, err := t.multilineIndent()
+	if err != nil {
+		return nil, err
+	}
+	return t.consumeStringBody(`"""`, indent)
+}
+
+// Skips up to `indent` spaces or tabs at the start of a line of a multi-line string
+func (t *Tokenizer) skipIndent(indent int) {
+	for i := 0; i < indent && (t.currentRune() == ' ' || t.currentRune() == '\t'); i++ {
+		t.skip(1)
+	}
+}
+
+// Consumes the contents of a string literal up to the closing quotes, decoding escape
+// sequences. Interpolated strings like `"{name} has {len(row)} fields"` are split into
+// string literal parts with the tokens of each embedded expression between
+// InterpolationStart and InterpolationEnd, so the token sequence always starts and
+// ends with a StringLiteral.
+//
+// Braces that look like regex quantifiers, such as `\\w{3}` or `{2,5}`, are kept as is
+// so that patterns don't need escaping.
+func (t *Tokenizer) consumeStringBody(closing string, indent int) ([]Token, error) {
+	var tokens []Token
+	var literal strings.Builder
+	multiline := closing == `"""`
+	lineStart := -1 // Start of the current line in literal, if it is on a line of its own
+	if multiline {
+		t.skipIndent(indent)
+		lineStart = 0
+	}
+	for {
+		if t.EOF() {
+			return tokens, fmt.Errorf("Unterminated string literal")
+		}
+		switch r := t.currentRune(); {
+		case t.atString(closing):
+			t.skip(len(closing))
+			str := literal.String()
+			if multiline && lineStart > 0 && strings.TrimSpace(str[lineStart:]) == "" {
+				str = str[:lineStart-1]
+			}
+			return append(tokens, t.createTokenFromString(StringLiteral, str)), nil
+		case r == '\n' && multiline:
+			literal.WriteRune(t.consume())
+			t.skipIndent(indent)
+			lineStart = literal.Len()
+		case r == '\\':
+			decoded, err := t.consumeEscape()
+			if err != nil {
+				return tokens, err
+			}
+			literal.WriteString(decoded)
+		case r == '{' && !quantifierRegex.MatchString(t.source[t.pos:]):
+			tokens = append(tokens, t.createTokenFromString(StringLiteral, literal.String()))
+			tokens = append(tokens, t.createTokenConsume(InterpolationStart, 1))
+			literal.Reset()
+			lineStart = -1
+			depth := 0
+			for {
+				token, err := t.nextToken()
+				if err != nil {
+					return tokens, err
+				}
+				if token.kind == Eof {
+					return tokens, fmt.Errorf("Unterminated string interpolation")
+				}
+				if token.kind == OpenCurly {
+					depth++
+				}
+				if token.kind == CloseCurly {
+					if depth == 0 {
+						token.kind = InterpolationEnd
+						tokens = append(tokens, token)
+						break
+					}
+					depth--
+				}
+				if token.kind != Whitespace && token.kind != Comment {
+					tokens = append(tokens, token)
+				}
+			}
+		default:
+			literal.WriteRune(t.consume())
+		}
+	}
+}
+
 func (t *Tokenizer) createTokenConsume(kind TokenKind, nchar int) Token {
 	return Token{
 		kind:   kind,
+		file:   t.fileNum,
 		str:    string(t.consumeMany(nchar)),
 		line:   t.currentLine,
 		column: t.currentColumn,
@@ -181,6 +366,7 @@ func (t *Tokenizer) createTokenConsume(kind TokenKind, nchar int) Token {
 func (t *Tokenizer) createTokenFromString(kind TokenKind, str string) Token {
 	return Token{
 		kind:   kind,
+		file:   t.fileNum,
 		str:    str,
 		line:   t.currentLine,
 		column: t.currentColumn,
@@ -189,6 +375,13 @@ func (t *Tokenizer) createTokenFromString(kind TokenKind, str string) Token {
 
 func (t *Tokenizer) nextToken() (Token, error) {
 
+	// Tokens already produced while consuming an interpolated string
+	if len(t.pending) > 0 {
+		token := t.pending[0]
+		t.pending = t.pending[1:]
+		return token, nil
+	}
+
 	if t.EOF() {
 		return t.createTokenFromString(Eof, ""), nil
 	}
@@ -198,7 +391,7 @@ func (t *Tokenizer) nextToken() (Token, error) {
 		return t.createTokenFromString(Whitespace, ""), nil
 	}
 
-	if t.atLetter() {
+	if t.atLetter() || t.currentRune() == '_' {
 		token := t.consumeIdentifier()
 		return token, nil
 	}
@@ -228,11 +421,17 @@ func (t *Tokenizer) nextToken() (Token, error) {
 		if t.peek(1) == '=' {
 			return t.createTokenConsume(GreaterEqual, 2), nil
 		}
+		if t.peek(1) == '>' {
+			return t.createTokenConsume(ShiftRight, 2), nil
+		}
 		return t.createTokenConsume(Greater, 1), nil
 	case '<':
 		if t.peek(1) == '=' {
 			return t.createTokenConsume(LessEqual, 2), nil
 		}
+		if t.peek(1) == '<' {
+			return t.createTokenConsume(ShiftLeft, 2), nil
+		}
 		return t.createTokenConsume(Less, 1), nil
 	case '-':
 		if t.peek(1) == '-' {
@@ -241,48 +440,93 @@ func (t *Tokenizer) nextToken() (Token, error) {
 		if t.peek(1) == '>' {
 			return t.createTokenConsume(RightArrow, 2), nil
 		}
+		if t.peek(1) == '=' {
+			return t.createTokenConsume(MinusAssign, 2), nil
+		}
 		return t.createTokenConsume(Minus, 1), nil
 	case '+':
 		if t.peek(1) == '+' {
 			return t.createTokenConsume(PlusPlus, 2), nil
 		}
+		if t.peek(1) == '=' {
+			return t.createTokenConsume(PlusAssign, 2), nil
+		}
 		return t.createTokenConsume(Plus, 1), nil
 	case '*':
+		if t.peek(1) == '*' {
+			return t.createTokenConsume(Power, 2), nil
+		}
+		if t.peek(1) == '=' {
+			return t.createTokenConsume(MultAssign, 2), nil
+		}
 		return t.createTokenConsume(Mult, 1), nil
+	case '%':
+		if t.peek(1) == '=' {
+			return t.createTokenConsume(ModuloAssign, 2), nil
+		}
+		return t.createTokenConsume(Modulo, 1), nil
+	case '^':
+		return t.createTokenConsume(BitXor, 1), nil
+	case '~':
+		if t.peek(1) == '/' {
+			return t.createTokenConsume(IntDiv, 2), nil
+		}
+		return Token{}, fmt.Errorf("Unknown token: %s", strconv.QuoteRune(t.currentRune()))
 	case '?':
 		return t.createTokenConsume(QuestionMark, 1), nil
 	case '/':
 		if t.peek(1) == '/' {
 			return t.createTokenFromString(Comment, t.consumeUntil('\n')), nil
 		}
+		if t.peek(1) == '=' {
+			return t.createTokenConsume(DivAssign, 2), nil
+		}
 		return t.createTokenConsume(Div, 1), nil
 	case ',':
 		return t.createTokenConsume(Comma, 1), nil
+	case ':':
+		return t.createTokenConsume(Colon, 1), nil
 	case '.':
 		if t.peek(1) == '.' {
+			if t.atString("..=") {
+				return t.createTokenConsume(RangeInclusive, 3), nil
+			}
 			return t.createTokenConsume(Range, 2), nil
 		}
 		return t.createTokenConsume(Period, 1), nil
+	case '`':
+		return t.consumeRawString()
 	case '"':
-		t.skip(1)
-		stringLiteral := t.consumeUntil('"')
-		t.skip(1)
-		return t.createTokenFromString(StringLiteral, stringLiteral), nil
+		var tokens []Token
+		var err error
+		if t.atString(`"""`) {
+			tokens, err = t.consumeMultilineString()
+		} else {
+			tokens, err = t.consumeString()
+		}
+		if err != nil {
+			return Token{}, err
+		}
+		t.pending = append(tokens[1:], t.pending...)
+		return tokens[0], nil
 	case '=':
 		if t.peek(1) == '=' {
 			return t.createTokenConsume(Equal, 2), nil
 		}
+		if t.peek(1) == '>' {
+			return t.createTokenConsume(FatArrow, 2), nil
+		}
 		return t.createTokenConsume(Assign, 1), nil
 	case '&':
 		if t.peek(1) == '&' {
 			return t.createTokenConsume(LogicAnd, 2), nil
 		}
-		return Token{}, fmt.Errorf("Single & token not yet suppoerted")
+		return t.createTokenConsume(BitAnd, 1), nil
 	case '|':
 		if t.peek(1) == '|' {
 			return t.createTokenConsume(LogicOr, 2), nil
 		}
-		return Token{}, fmt.Errorf("Single | token not yet suppoerted")
+		return t.createTokenConsume(BitOr, 1), nil
 	case '!':
 		if t.peek(1) == '=' {
 			return t.createTokenConsume(NotEqual, 2), nil
@@ -1,18 +1,40 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 )
 
 type TypeChecker struct {
-	scope   *Scope
-	errors  []string
-	imports map[string]bool
+	scope       *Scope
+	errors      []string
+	imports     map[string]bool
+	strict      bool
+	strictFiles map[int]bool
+	fileNames   []string
+	// Constraints on the type parameters of the generic function being checked
+	typeParamConstraints map[string]TypeConstraint
+	instantiations       []instantiation
+}
+
+// A call to a generic function, its type arguments are checked against the constraints
+// of the type parameters once all function bodies have been checked
+type instantiation struct {
+	call        *FunctionCallNode
+	symbol      Symbol
+	constraints map[string]TypeConstraint // Of the generic function the call is in, if any
 }
 
 func (tc *TypeChecker) error(errorStr string) {
+	// Expressions can be checked more than once, only report each error once
+	if slices.Contains(tc.errors, errorStr) {
+		return
+	}
 	tc.errors = append(tc.errors, fmt.Sprintf("%s", errorStr))
 }
 
@@ -20,6 +42,16 @@ func (tc *TypeChecker) addImport(name string) {
 	tc.imports[name] = true
 }
 
+// Reports values that would be implicitly converted to another type, for files in strict mode
+func (tc *TypeChecker) checkImplicitConversion(from Type, to Type, token Token, context string) {
+	if !tc.strict && !tc.strictFiles[token.file] {
+		return
+	}
+	if isImplicitConversion(from, to) {
+		tc.error(fmt.Sprintf("%s:%d:%d: strict mode: %s is implicitly converted to %s %s", tc.fileNames[token.file], token.line+1, token.column, from, to, context))
+	}
+}
+
 func (tc *TypeChecker) typecheckBuiltin(node Node) Type {
 	var returnType Type
 	fnNode := node.(*FunctionCallNode)
@@ -27,7 +59,8 @@ func (tc *TypeChecker) typecheckBuiltin(node Node) Type {
 	if !isGeneric(builtin.returnType) {
 		returnType = builtin.returnType
 	} else {
-		panic("Resolve generic return type")
+		// Generic return types are resolved from the arguments below
+		returnType = TypeUndetermined{}
 	}
 
 	err := fnNode.matchArgsToParams(builtin.parameters)
@@ -35,6 +68,17 @@ func (tc *TypeChecker) typecheckBuiltin(node Node) Type {
 		tc.error(err.Error())
 	}
 
+	// Arguments for parameters of a given scalar type are converted to that type
+	if err == nil {
+		for _, param := range builtin.parameters {
+			switch param.typ.(type) {
+			case TypeInt, TypeFloat, TypeString, TypeBool:
+				argType := tc.typecheckExpr(fnNode.resolvedArgs[param.name].expr)
+				tc.checkImplicitConversion(argType, param.typ, fnNode.token, fmt.Sprintf("for argument %q of %q", param.name, builtin.name))
+			}
+		}
+	}
+
 	switch builtin.name {
 	case "append":
 		containerType := tc.typecheckExpr(fnNode.resolvedArgs["dest"].expr)
@@ -42,28 +86,65 @@ func (tc *TypeChecker) typecheckBuiltin(node Node) Type {
 			tc.error(fmt.Sprintf("append() cannot be used on type %q", containerType))
 		}
 		node.(*FunctionCallNode).setArgType("dest", containerType)
+		valueType := tc.typecheckExpr(fnNode.resolvedArgs["var"].expr)
+		switch t := containerType.(type) {
+		case TypeSlice:
+			if valueType != containerType {
+				tc.checkImplicitConversion(valueType, t.ElementType, fnNode.token, "when appended")
+			}
+		case TypeString:
+			tc.checkImplicitConversion(valueType, t, fnNode.token, "when appended")
+		}
 
 	case "add":
 		containerType := tc.typecheckExpr(fnNode.resolvedArgs["dest"].expr)
 		if !isSettable(containerType) {
 			tc.error(fmt.Sprintf("add() can only be used on sets, not %q", containerType))
+		} else {
+			valueType := tc.typecheckExpr(fnNode.resolvedArgs["var"].expr)
+			tc.checkImplicitConversion(valueType, containerType.(TypeSet).ElementType, fnNode.token, "when added to set")
 		}
 		node.(*FunctionCallNode).setArgType("dest", containerType)
 
 	case "has":
 		containerType := tc.typecheckExpr(fnNode.resolvedArgs["haystack"].expr)
 		// TODO: Allow contains to be used on slices and strings too?
-		if !isSettable(containerType) {
-			tc.error(fmt.Sprintf("contains() can only be used on sets, not %q", containerType))
+		if !isKeyed(containerType) {
+			tc.error(fmt.Sprintf("has() can only be used on sets and maps, not %q", containerType))
+		} else {
+			needleType := tc.typecheckExpr(fnNode.resolvedArgs["needle"].expr)
+			tc.checkImplicitConversion(needleType, containerType.(IterableType).GetElementType(), fnNode.token, "when looked up")
 		}
 		node.(*FunctionCallNode).setArgType("haystack", containerType)
 
 	case "del":
-		containerType := tc.typecheckExpr(fnNode.resolvedArgs["set"].expr)
-		if !isSettable(containerType) {
-			tc.error(fmt.Sprintf("del() can only be used on sets, not %q", containerType))
+		containerType := tc.typecheckExpr(fnNode.resolvedArgs["container"].expr)
+		if !isKeyed(containerType) {
+			tc.error(fmt.Sprintf("del() can only be used on sets and maps, not %q", containerType))
+		} else {
+			valueType := tc.typecheckExpr(fnNode.resolvedArgs["value"].expr)
+			tc.checkImplicitConversion(valueType, containerType.(IterableType).GetElementType(), fnNode.token, "when deleted")
+		}
+		node.(*FunctionCallNode).setArgType("container", containerType)
+
+	case "keys", "values", "get":
+		containerType := tc.typecheckExpr(fnNode.resolvedArgs["map"].expr)
+		mapType, isMap := containerType.(TypeMap)
+		if !isMap {
+			tc.error(fmt.Sprintf("%s() can only be used on maps, not %q", builtin.name, containerType))
+			break
+		}
+		node.(*FunctionCallNode).setArgType("map", containerType)
+		switch builtin.name {
+		case "keys":
+			returnType = TypeSlice{ElementType: mapType.KeyType}
+		case "values":
+			returnType = TypeSlice{ElementType: mapType.ValueType}
+		case "get":
+			returnType = mapType.ValueType
+			tc.checkImplicitConversion(tc.typecheckExpr(fnNode.resolvedArgs["key"].expr), mapType.KeyType, fnNode.token, "when used as key")
+			tc.checkImplicitConversion(tc.typecheckExpr(fnNode.resolvedArgs["default"].expr), mapType.ValueType, fnNode.token, "when used as default value")
 		}
-		node.(*FunctionCallNode).setArgType("set", containerType)
 
 	case "union":
 		set1Type := tc.typecheckExpr(fnNode.resolvedArgs["set1"].expr)
@@ -85,19 +166,32 @@ func (tc *TypeChecker) typecheckBuiltin(node Node) Type {
 	case "to_set":
 		containerType := tc.typecheckExpr(fnNode.resolvedArgs["slice"].expr)
 		if _, isSlice := containerType.(TypeSlice); !isSlice {
-			tc.error(fmt.Sprintf("to_setn() can only be used on slices", containerType))
+			tc.error(fmt.Sprintf("to_set() can only be used on slices, not %q", containerType))
 		}
 		returnType = TypeSet{ElementType: containerType.(TypeSlice).ElementType}
+		constrainTypeParam(tc.typeParamConstraints, returnType.(TypeSet).ElementType, OrderedConstraint)
 
 	case "len":
 		containerType := tc.typecheckExpr(fnNode.resolvedArgs["var"].expr)
-		if !isAppendable(containerType) {
+		if !isAppendable(containerType) && !isKeyed(containerType) {
 			tc.error(fmt.Sprintf("len() cannot be used on type %q", containerType))
 		}
+		node.(*FunctionCallNode).setArgType("var", containerType)
+	case "int", "float", "str", "bool":
+		valueType := tc.typecheckExpr(fnNode.resolvedArgs["value"].expr)
+		if !isConvertible(valueType, builtin.returnType) {
+			tc.error(fmt.Sprintf("Cannot convert %s to %s", valueType, builtin.returnType))
+		}
+		node.(*FunctionCallNode).setArgType("value", valueType)
+	case "len_bytes", "bytes", "to_int", "to_float":
+		strType := tc.typecheckExpr(fnNode.resolvedArgs["str"].expr)
+		if _, isString := strType.(TypeString); !isString {
+			tc.error(fmt.Sprintf("%s() can only be used on strings, not %q", builtin.name, strType))
+		}
 	case "join":
 		containerType := tc.typecheckExpr(fnNode.resolvedArgs["list"].expr)
 		if _, isSlice := containerType.(TypeSlice); !isSlice {
-			tc.error(fmt.Sprintf("join() can only be used on slices", containerType))
+			tc.error(fmt.Sprintf("join() can only be used on slices, not %q", containerType))
 		}
 		node.(*FunctionCallNode).setArgType("list", containerType)
 	case "read":
@@ -110,31 +204,140 @@ func (tc *TypeChecker) typecheckBuiltin(node Node) Type {
 		} else {
 			returnType = TypeSlice{ElementType: TypeString{}}
 		}
+		// Without `->` all lines are read at once, unless a higher-order builtin consumes them
+		if fnNode.streamed {
+			returnType = TypeGenerator{ElementType: returnType}
+		} else if fnNode.generatorBody == nil {
+			returnType = TypeSlice{ElementType: returnType}
+		}
+	case "map", "filter", "reduce", "any", "all", "sort_by":
+		if err == nil {
+			returnType = tc.typecheckCollectionBuiltin(fnNode, builtin)
+		}
 	case "split", "match", "capture", "find", "slurp":
 		// Do nothing?
 	default:
 		panic(fmt.Sprintf("Typechecking not implemented for builtin %q", builtin.name))
 	}
+	fnNode.resolvedReturnType = returnType
 	return returnType
 }
 
-func (tc *TypeChecker) typecheckExprList(nodes []Node) Type {
+// Explicit conversions, eg. `int("12")`, are possible between all scalar types except
+// from bool to numbers. Anything with a length can be converted to bool.
+func isConvertible(from Type, to Type) bool {
+	switch from.(type) {
+	case TypeInt, TypeFloat, TypeString:
+		return true
+	case TypeBool:
+		return to == (TypeBool{}) || to == (TypeString{})
+	case TypeSlice, TypeSet, TypeMap:
+		return to == (TypeBool{})
+	}
+	return false
+}
+
+// Whether values of one type can be coerced to another without loss, eg. when assigned
+// to an element of a container: ints to floats and numbers to strings, element-wise
+func isCoercible(from Type, to Type) bool {
+	common, ok := commonType(from, to)
+	return ok && common == to
+}
+
+// Values are implicitly converted when their type differs from the expected type.
+// Widening ints to floats does not count, since no information is lost.
+func isImplicitConversion(from Type, to Type) bool {
+	if from == to {
+		return false
+	}
+	switch t := to.(type) {
+	case TypeFloat:
+		return from != (TypeInt{})
+	case TypeSlice:
+		if fromSlice, isSlice := from.(TypeSlice); isSlice {
+			return isImplicitConversion(fromSlice.ElementType, t.ElementType)
+		}
+	case TypeSet:
+		if fromSet, isSet := from.(TypeSet); isSet {
+			return isImplicitConversion(fromSet.ElementType, t.ElementType)
+		}
+	case TypeMap:
+		if fromMap, isMap := from.(TypeMap); isMap {
+			return isImplicitConversion(fromMap.KeyType, t.KeyType) || isImplicitConversion(fromMap.ValueType, t.ValueType)
+		}
+	case TypeTuple:
+		if fromTuple, isTuple := from.(TypeTuple); isTuple && fromTuple.Size == t.Size {
+			for i := 0; i < t.Size; i++ {
+				if isImplicitConversion(fromTuple.Elements[i], t.Elements[i]) {
+					return true
+				}
+			}
+			return false
+		}
+	case TypeAny, TypeUndetermined, TypeVoid, NoCoercion:
+		return false
+	}
+	if _, isUndetermined := from.(TypeUndetermined); isUndetermined {
+		return false
+	}
+	return true
+}
+
+// Returns the type that values of both types can be coerced to, eg. []float for []int and []float
+func commonType(a Type, b Type) (Type, bool) {
+	if a == b {
+		return a, true
+	}
+
+	// Scalars are coerced to the one with the highest precedence
 	typeCoercionPrecedence := map[Type]int{TypeString{}: 3, TypeFloat{}: 2, TypeInt{}: 1}
-	var coercionType Type
-	var highestPrecedence int
+	precedenceA, coercibleA := typeCoercionPrecedence[a]
+	precedenceB, coercibleB := typeCoercionPrecedence[b]
+	if coercibleA && coercibleB {
+		if precedenceA > precedenceB {
+			return a, true
+		}
+		return b, true
+	}
+
+	// Containers of the same kind are coerced element-wise
+	switch a := a.(type) {
+	case TypeSlice:
+		if b, isSlice := b.(TypeSlice); isSlice {
+			elementType, ok := commonType(a.ElementType, b.ElementType)
+			return TypeSlice{ElementType: elementType}, ok
+		}
+	case TypeSet:
+		if b, isSet := b.(TypeSet); isSet {
+			elementType, ok := commonType(a.ElementType, b.ElementType)
+			return TypeSet{ElementType: elementType}, ok
+		}
+	case TypeMap:
+		if b, isMap := b.(TypeMap); isMap {
+			keyType, keyOk := commonType(a.KeyType, b.KeyType)
+			valueType, valueOk := commonType(a.ValueType, b.ValueType)
+			return TypeMap{KeyType: keyType, ValueType: valueType}, keyOk && valueOk
+		}
+	}
+	return a, false
+}
+
+func (tc *TypeChecker) typecheckExprList(nodes []Node) Type {
+	var listType Type
 	for _, elem := range nodes {
 		typ := tc.typecheckExpr(elem)
-		precedence, found := typeCoercionPrecedence[typ]
-		if !found {
-			tc.error(fmt.Sprintf("Type %s not allowed in expression list", typ))
+		if listType == nil {
+			listType = typ
 			continue
 		}
-		if precedence > highestPrecedence {
-			highestPrecedence = precedence
-			coercionType = typ
+		common, ok := commonType(listType, typ)
+		if !ok {
+			tc.error(fmt.Sprintf("Type %s not allowed in expression list of type %s", typ, listType))
+			continue
 		}
+		listType = common
 	}
-	return coercionType
+	return listType
 }
 
 func (tc *TypeChecker) typecheckExpr(node Node) Type {
@@ -143,35 +346,79 @@ func (tc *TypeChecker) typecheckExpr(node Node) Type {
 		return n.NumType()
 	case *StringLiteralNode:
 		return TypeString{}
+	case *InterpolatedStringNode:
+		n.types = nil
+		for _, part := range n.parts {
+			partType := tc.typecheckExpr(part)
+			switch partType.(type) {
+			case TypeString, TypeInt, TypeFloat, TypeBool, TypeParam, TypeEnum:
+			default:
+				tc.error(fmt.Sprintf("Cannot interpolate value of type %s into a string", partType))
+			}
+			n.types = append(n.types, partType)
+		}
+		return TypeString{}
 	case *BoolNode:
 		return TypeBool{}
 	case *SliceLiteralNode:
-		elementType := tc.typecheckExprList(n.elements)
-		n.elementType = elementType
-		return TypeSlice{ElementType: elementType}
+		// Typed literals, eg. `[]float{1, 2}`, already know their element type
+		if n.elementType != nil {
+			for _, elem := range n.elements {
+				tc.typecheckExpr(elem)
+			}
+			return TypeSlice{ElementType: n.elementType}
+		}
+		n.elementType = tc.typecheckExprList(n.elements)
+		return TypeSlice{ElementType: n.elementType}
 	case *SetLiteralNode:
 		elementType := tc.typecheckExprList(n.elements)
 		n.elementType = elementType
+		constrainTypeParam(tc.typeParamConstraints, elementType, OrderedConstraint)
 		return TypeSet{ElementType: elementType}
+	case *MapLiteralNode:
+		return tc.typecheckMapLiteral(n)
+	case *TupleLiteralNode:
+		var types []Type
+		for _, element := range n.elements {
+			types = append(types, tc.typecheckExpr(element))
+		}
+		n.typ = newTupleType(types)
+		return n.typ
 	case *BinOpNode:
 		leftType := tc.typecheckExpr(n.left)
 		rightType := tc.typecheckExpr(n.right)
+		if n.isArithmetic() {
+			return tc.typecheckArithmetic(n, leftType, rightType)
+		}
 		if leftType == rightType {
-			return leftType
+			n.operandType = leftType
+		} else if leftType.String() == "float" || rightType.String() == "float" {
+			n.operandType = TypeFloat{}
+		} else if leftType.String() == "string" || rightType.String() == "string" {
+			n.operandType = TypeFloat{}
+		} else {
+			n.operandType = TypeInt{}
 		}
-		if leftType.String() == "float" || rightType.String() == "float" {
-			return TypeFloat{}
+		if n.token.kind == LogicAnd || n.token.kind == LogicOr {
+			n.operandType = TypeBool{}
 		}
-		if leftType.String() == "string" || rightType.String() == "string" {
-			return TypeFloat{}
+		if n.isComparison() {
+			return TypeBool{}
 		}
-		return TypeInt{}
+		return n.operandType
 
 	case *UnaryOpNode:
-		return tc.typecheckExpr(n.expr)
+		n.typ = tc.typecheckExpr(n.expr)
+		if n.token.kind == Minus && n.typ != (TypeInt{}) && n.typ != (TypeFloat{}) {
+			tc.error(fmt.Sprintf("Cannot negate value of type %s", n.typ))
+		}
+		return n.typ
 
 	case *VarNode:
 		varSymbol, found := tc.scope.lookupSymbol(n.token.str)
+		if found && varSymbol.category == FunctionSymbol {
+			return tc.typecheckFunctionValue(varSymbol)
+		}
 		if found {
 			return varSymbol.typ
 		}
@@ -179,48 +426,84 @@ func (tc *TypeChecker) typecheckExpr(node Node) Type {
 		os.Exit(1)
 
 	case *IndexedVarNode:
-		varSymbol, found := tc.scope.lookupSymbol(n.token.str)
-		if !found {
+		if _, found := tc.scope.lookupSymbol(n.token.str); !found {
 			fmt.Println("UNREACHABLE: Trying to look up type of undefined indexed variable")
 			os.Exit(1)
 		}
-		switch t := varSymbol.typ.(type) {
+		if n.container != nil {
+			tc.typecheckExpr(n.container)
+		}
+		switch t := containerType(tc.scope, n).(type) {
 		case TypeSlice:
+			if _, isRange := n.index.(*RangeNode); isRange {
+				return t
+			}
 			return t.ElementType
 		case TypeString:
 			return TypeString{}
+		case TypeMap:
+			if _, isRange := n.index.(*RangeNode); isRange {
+				tc.error(fmt.Sprintf("Cannot index map %q with a range", n.token.str))
+			}
+			return t.ValueType
+		case TypeUndetermined:
+			return TypeUndetermined{}
 		default:
-			fmt.Printf("%s is not indexable\n", t)
+			if n.container != nil {
+				tc.error(fmt.Sprintf("Cannot index element of %q of type %s", n.token.str, t))
+			} else {
+				tc.error(fmt.Sprintf("Cannot index %q of type %s", n.token.str, t))
+			}
+			return TypeUndetermined{}
 		}
 
+	case *FieldAccessNode:
+		return tc.typecheckFieldAccess(n)
+
 	case *FunctionCallNode:
 		fnNode := n
 		functionName := fnNode.name
-		if isBuiltin(functionName) {
+		if fnNode.isBuiltin {
 			return tc.typecheckBuiltin(fnNode)
 		}
 
+		if functionName == "print" {
+			return TypeVoid{}
+		}
+
 		funcSymbol, found := tc.scope.lookupSymbol(functionName)
 
 		if found {
-			err := fnNode.matchArgsToParams(funcSymbol.paramsNode.parameters)
+			parameters, returnType, isCallable := funcSymbol.signature()
+			if !isCallable {
+				return TypeUndetermined{}
+			}
+			err := fnNode.matchArgsToParams(parameters)
 			if err != nil {
 				tc.error(err.Error())
+			} else {
+				parameters, returnType = tc.instantiateGeneric(fnNode, funcSymbol, parameters, returnType)
+				tc.inferArgumentLambdas(fnNode, parameters)
 			}
-			return funcSymbol.typ
+			if _, isGenerator := returnType.(TypeGenerator); isGenerator && !fnNode.streamed {
+				tc.error(fmt.Sprintf("Generator function %q must be consumed with \"->\"", functionName))
+			}
+			return returnType
 		}
 		fmt.Println("UNREACHABLE: Trying to look up type of non-existing function")
 		os.Exit(1)
 
 	case *RangeNode:
-		fromType := tc.typecheckExpr(n.from)
-		if (fromType != TypeInt{}) {
+		// Open ends, eg. `elements[3..]`, are only possible when indexing
+		if _, isOpen := n.from.(*NoOpNode); !isOpen && tc.typecheckExpr(n.from) != (TypeInt{}) {
 			tc.error("The from value of a range must be integer")
 		}
-		toType := tc.typecheckExpr(n.from)
-		if (toType != TypeInt{}) {
+		if _, isOpen := n.to.(*NoOpNode); !isOpen && tc.typecheckExpr(n.to) != (TypeInt{}) {
 			tc.error("The to value of a range must be integer")
 		}
+		if _, noStep := n.step.(*NoOpNode); !noStep && tc.typecheckExpr(n.step) != (TypeInt{}) {
+			tc.error("The step of a range must be integer")
+		}
 		return TypeSlice{ElementType: TypeInt{}}
 	case *AssignNode:
 		if n.expression == false {
@@ -234,23 +517,709 @@ func (tc *TypeChecker) typecheckExpr(node Node) Type {
 		}
 		return tc.typecheckExpr(n.right)
 
+	case *LambdaNode:
+		return tc.typecheckLambda(n)
+
+	case *MatchNode:
+		return tc.typecheckMatch(n)
+
+	case *IfExprNode:
+		return tc.typecheckIfExpr(n)
+
+	case *NoOpNode:
+		return TypeVoid{}
 	default:
-		fmt.Printf("TODO: Typechecking not implemented for: %T\n")
+		fmt.Printf("TODO: Typechecking not implemented for: %T\n", node)
 		os.Exit(1)
 	}
 	return TypeUndetermined{}
 }
 
+// Conditions that are plain values, eg. `if count {`, are converted to bool by their truthiness
+func (tc *TypeChecker) checkCondition(condition Node, conditionType Type, token Token) {
+	switch condition.(type) {
+	case *VarNode, *AssignNode:
+		tc.checkImplicitConversion(conditionType, TypeBool{}, token, "in condition")
+	}
+}
+
+// The operands of logical operators are converted to bool, and the operands of
+// other operators to their common type
+func (tc *TypeChecker) checkOperands(node *BinOpNode) {
+	tc.typecheckExpr(node)
+	for _, operandType := range []Type{tc.typecheckExpr(node.left), tc.typecheckExpr(node.right)} {
+		// Values of a type parameter can be compared for equality, which constrains its type arguments
+		_, isTypeParam := operandType.(TypeParam)
+		if isTypeParam && (node.token.kind == Equal || node.token.kind == NotEqual) {
+			constrainTypeParam(tc.typeParamConstraints, operandType, ComparableConstraint)
+		} else if hasTypeParams(operandType) {
+			tc.error(fmt.Sprintf("Operator %s cannot be used on values of generic type %s", node.token.str, operandType))
+		}
+		// Go can only compare functions to nil
+		if _, isFunction := operandType.(TypeFunction); isFunction && node.isComparison() {
+			tc.error(fmt.Sprintf("Cannot compare function values with %s", node.token.str))
+		}
+		// Enums are checked with `match`, Go cannot compare variants holding slices
+		if enum, isEnum := operandType.(TypeEnum); isEnum && node.isComparison() {
+			tc.error(fmt.Sprintf("Cannot compare %s values with %s, use `match` to check the variant", enum.Name, node.token.str))
+		}
+	}
+	context := fmt.Sprintf("by %s", node.token.str)
+	tc.checkImplicitConversion(tc.typecheckExpr(node.left), node.operandType, node.token, context)
+	tc.checkImplicitConversion(tc.typecheckExpr(node.right), node.operandType, node.token, context)
+}
+
+// Calls to functions without a return value can only be used as statements, eg. not `x = print(3)`
+func (tc *TypeChecker) checkHasValue(node Node, context string) {
+	if tc.typecheckExpr(node) != (TypeVoid{}) {
+		return
+	}
+	if fnNode, isCall := node.(*FunctionCallNode); isCall {
+		tc.error(fmt.Sprintf("Function %q does not return a value, it cannot be used %s", fnNode.name, context))
+	} else {
+		tc.error(fmt.Sprintf("Expression does not have a value, it cannot be used %s", context))
+	}
+}
+
+// Named functions can be used as values, as long as they can be called like any other function
+func (tc *TypeChecker) typecheckFunctionValue(symbol Symbol) Type {
+	if symbol.fallible {
+		tc.error(fmt.Sprintf("Fallible function %q cannot be used as a value", symbol.name))
+	}
+	if _, isGenerator := symbol.typ.(TypeGenerator); isGenerator {
+		tc.error(fmt.Sprintf("Generator function %q cannot be used as a value", symbol.name))
+	}
+	if len(symbol.typeParams) > 0 {
+		tc.error(fmt.Sprintf("Generic function %q cannot be used as a value", symbol.name))
+	}
+	return symbol.functionType()
+}
+
+// Generic functions are instantiated with the types of their arguments, eg. `T` becomes
+// `int` when `first<T>(xs []T)` is called with a []int. Returns the parameters and return
+// type of the instantiated function.
+func (tc *TypeChecker) instantiateGeneric(fnNode *FunctionCallNode, symbol Symbol, parameters []ParameterNode, returnType Type) ([]ParameterNode, Type) {
+	if len(symbol.typeParams) == 0 {
+		return parameters, returnType
+	}
+
+	if fnNode.typeArgs == nil {
+		bindings := make(map[string]Type)
+
+		// Lambdas are bound last, the types of their parameters may come from other arguments
+		for _, bindLambdas := range []bool{false, true} {
+			for _, param := range parameters {
+				arg := fnNode.resolvedArgs[param.name].expr
+				if _, isLambda := arg.(*LambdaNode); isLambda != bindLambdas {
+					continue
+				}
+				tc.inferLambda(arg, substituteTypeParams(param.typ, bindings))
+				bindTypeParams(param.typ, tc.typecheckExpr(arg), bindings)
+			}
+		}
+		fnNode.typeArgs = bindings
+
+		inferred := true
+		for _, name := range symbol.typeParams {
+			if _, found := bindings[name]; !found {
+				tc.error(fmt.Sprintf("Cannot infer type parameter %s of %q from its arguments", name, symbol.name))
+				inferred = false
+			}
+		}
+		if inferred {
+			tc.instantiations = append(tc.instantiations, instantiation{fnNode, symbol, tc.typeParamConstraints})
+		}
+
+		// Arguments for generic parameters are never converted
+		for _, param := range parameters {
+			if !inferred || !hasTypeParams(param.typ) {
+				continue
+			}
+			argType := tc.typecheckExpr(fnNode.resolvedArgs[param.name].expr)
+			expected := substituteTypeParams(param.typ, bindings)
+			if _, isUndetermined := argType.(TypeUndetermined); !isUndetermined && argType != expected {
+				tc.error(fmt.Sprintf("Cannot use %s as %s for argument %q of %q", argType, expected, param.name, symbol.name))
+			}
+		}
+	}
+
+	var instantiated []ParameterNode
+	for _, param := range parameters {
+		param.typ = substituteTypeParams(param.typ, fnNode.typeArgs)
+		instantiated = append(instantiated, param)
+	}
+	return instantiated, substituteTypeParams(returnType, fnNode.typeArgs)
+}
+
+// Checks the type arguments of generic calls against the constraints of their type parameters.
+// Type parameters passed on to another generic function get the constraints of its parameters.
+func (tc *TypeChecker) checkTypeArgs() {
+	for changed := true; changed; {
+		changed = false
+		for _, inst := range tc.instantiations {
+			for name, constraint := range inst.symbol.typeParamConstraints {
+				if param, isParam := inst.call.typeArgs[name].(TypeParam); isParam && inst.constraints[param.Name] < constraint {
+					inst.constraints[param.Name] = constraint
+					changed = true
+				}
+			}
+		}
+	}
+
+	for _, inst := range tc.instantiations {
+		for _, name := range inst.symbol.typeParams {
+			typeArg := inst.call.typeArgs[name]
+			if _, isParam := typeArg.(TypeParam); isParam {
+				continue
+			}
+			if constraint := inst.symbol.typeParamConstraints[name]; !constraint.satisfiedBy(typeArg) {
+				tc.error(fmt.Sprintf("Cannot use %s for type parameter %s of %q, it must be %s", typeArg, name, inst.symbol.name, constraint))
+			}
+		}
+	}
+}
+
+func (tc *TypeChecker) typecheckLambda(node *LambdaNode) Type {
+	// A mismatch with the expected function type has already been reported
+	if expected, isFunction := node.expected.(TypeFunction); isFunction && expected.ParamCount != len(node.parameters) {
+		return TypeUndetermined{}
+	}
+
+	var params []Type
+	for _, param := range node.parameters {
+		if _, isUndetermined := param.typ.(TypeUndetermined); isUndetermined {
+			tc.error(fmt.Sprintf("Cannot infer the type of lambda parameter %q, give it a type, eg. `(%s int) => ...`", param.name, param.name))
+			return TypeUndetermined{}
+		}
+		params = append(params, param.typ)
+	}
+
+	prevScope := tc.scope
+	tc.scope = node.scope
+	node.bodyType = tc.typecheckExpr(node.body)
+	tc.scope = prevScope
+
+	returnType := node.bodyType
+	if expected, isFunction := node.expected.(TypeFunction); isFunction && expected.ReturnType != (TypeUndetermined{}) {
+		returnType = expected.ReturnType
+	}
+	node.typ = newFunctionType(params, returnType)
+	return node.typ
+}
+
+// Higher-order builtins work on the elements of slices, sets and generators, and take the
+// function to apply as a value, eg. `rows.filter(valid).map(score)`. Generator calls are
+// consumed one value at a time, eg. `read(path)?.filter(valid)`, and map() and filter()
+// pass them on as generators when another builtin consumes their result.
+func (tc *TypeChecker) typecheckCollectionBuiltin(fnNode *FunctionCallNode, builtin BuiltinFunc) Type {
+	list := fnNode.resolvedArgs["list"].expr
+	if call, isCall := list.(*FunctionCallNode); isCall && call.generatorBody == nil {
+		call.streamed = true
+	}
+	listType := tc.typecheckExpr(list)
+	fnNode.setArgType("list", listType)
+
+	var elementType Type
+	switch t := listType.(type) {
+	case TypeSlice:
+		elementType = t.ElementType
+	case TypeSet:
+		elementType = t.ElementType
+	case TypeGenerator:
+		elementType = t.ElementType
+	default:
+		tc.error(fmt.Sprintf("%s() can only be used on slices, sets and generators, not %q", builtin.name, listType))
+		return TypeUndetermined{}
+	}
+	_, isGenerator := listType.(TypeGenerator)
+
+	switch builtin.name {
+	case "map":
+		f := tc.typecheckFunctionArgument(fnNode, "f", newFunctionType([]Type{elementType}, TypeUndetermined{}))
+		if f.ReturnType == (TypeVoid{}) {
+			tc.error("map() needs a function that returns a value")
+		}
+		if isGenerator && fnNode.streamed {
+			return TypeGenerator{ElementType: f.ReturnType}
+		}
+		return TypeSlice{ElementType: f.ReturnType}
+	case "filter":
+		tc.typecheckFunctionArgument(fnNode, "f", newFunctionType([]Type{elementType}, TypeBool{}))
+		if isGenerator && !fnNode.streamed {
+			return TypeSlice{ElementType: elementType}
+		}
+		return listType
+	case "any", "all":
+		tc.typecheckFunctionArgument(fnNode, "f", newFunctionType([]Type{elementType}, TypeBool{}))
+		return TypeBool{}
+	case "reduce":
+		initialType := tc.typecheckExpr(fnNode.resolvedArgs["initial"].expr)
+		fnNode.setArgType("initial", initialType)
+		tc.typecheckFunctionArgument(fnNode, "f", newFunctionType([]Type{initialType, elementType}, initialType))
+		return initialType
+	case "sort_by":
+		key := tc.typecheckFunctionArgument(fnNode, "key", newFunctionType([]Type{elementType}, TypeUndetermined{}))
+		switch key.ReturnType.(type) {
+		case TypeInt, TypeFloat, TypeString, TypeUndetermined:
+		default:
+			tc.error(fmt.Sprintf("sort_by() needs a key of type int, float or str, not %s", key.ReturnType))
+		}
+		return TypeSlice{ElementType: elementType}
+	}
+	panic("UNREACHABLE")
+}
+
+// Checks a function passed to a builtin. An undetermined return type in the expected
+// function type accepts functions returning anything.
+func (tc *TypeChecker) typecheckFunctionArgument(fnNode *FunctionCallNode, paramName string, expected TypeFunction) TypeFunction {
+	arg := fnNode.resolvedArgs[paramName]
+	tc.inferLambda(arg.expr, expected)
+	argType := tc.typecheckExpr(arg.expr)
+	fnNode.setArgType(paramName, argType)
+
+	functionType, isFunction := argType.(TypeFunction)
+	if !isFunction {
+		if _, isUndetermined := argType.(TypeUndetermined); !isUndetermined {
+			tc.error(fmt.Sprintf("Argument %q of %q must be a function, not %s", paramName, fnNode.name, argType))
+		}
+		return expected
+	}
+	if _, anyReturn := expected.ReturnType.(TypeUndetermined); anyReturn {
+		expected.ReturnType = functionType.ReturnType
+	}
+	tc.checkExactType(functionType, expected, fmt.Sprintf("for argument %q of %q", paramName, fnNode.name))
+	return functionType
+}
+
+// Lambda parameters without types get them from the function type expected where the lambda is used
+func (tc *TypeChecker) inferLambda(node Node, expected Type) {
+	lambda, isLambda := node.(*LambdaNode)
+	functionType, isFunction := expected.(TypeFunction)
+	if !isLambda || !isFunction {
+		return
+	}
+	lambda.expected = functionType
+	if len(lambda.parameters) != functionType.ParamCount {
+		if functionType.ReturnType == (TypeUndetermined{}) {
+			tc.error(fmt.Sprintf("Lambda with %d parameters cannot be used where a function with %d parameters is expected", len(lambda.parameters), functionType.ParamCount))
+		} else {
+			tc.error(fmt.Sprintf("Lambda with %d parameters cannot be used as %s", len(lambda.parameters), functionType))
+		}
+		return
+	}
+	for i, param := range lambda.parameters {
+		if _, isUndetermined := param.typ.(TypeUndetermined); isUndetermined {
+			lambda.parameters[i].typ = functionType.Params[i]
+			lambda.scope.setSymbolType(param.name, functionType.Params[i])
+		}
+	}
+}
+
+func (tc *TypeChecker) inferArgumentLambdas(node *FunctionCallNode, parameters []ParameterNode) {
+	for _, param := range parameters {
+		if arg, found := node.resolvedArgs[param.name]; found {
+			tc.inferLambda(arg.expr, param.typ)
+		}
+	}
+}
+
+// Functions and values of generic types are never converted, their types must match exactly
+func (tc *TypeChecker) checkExactType(from Type, to Type, context string) {
+	_, fromFunction := from.(TypeFunction)
+	_, toFunction := to.(TypeFunction)
+	isGenericType := hasTypeParams(from) || hasTypeParams(to)
+	_, isUndetermined := from.(TypeUndetermined)
+	if (fromFunction || toFunction || isGenericType) && from != to && !isUndetermined {
+		tc.error(fmt.Sprintf("Cannot use %s as %s %s", from, to, context))
+	}
+}
+
+func (tc *TypeChecker) typecheckArithmetic(node *BinOpNode, leftType Type, rightType Type) Type {
+	isNumber := func(typ Type) bool { return typ == (TypeInt{}) || typ == (TypeFloat{}) }
+
+	switch node.token.kind {
+	case BitAnd, BitOr, BitXor, ShiftLeft, ShiftRight:
+		if leftType != (TypeInt{}) || rightType != (TypeInt{}) {
+			tc.error(fmt.Sprintf("Operator %s requires int operands, got %s and %s", node.token.str, leftType, rightType))
+		}
+		node.operandType = TypeInt{}
+	default:
+		if !isNumber(leftType) || !isNumber(rightType) {
+			tc.error(fmt.Sprintf("Operator %s requires numeric operands, got %s and %s", node.token.str, leftType, rightType))
+		}
+		node.operandType = TypeInt{}
+		if leftType == (TypeFloat{}) || rightType == (TypeFloat{}) {
+			node.operandType = TypeFloat{}
+		}
+	}
+
+	// Ints raised to a negative power are not ints, eg. 2 ** -1
+	if num, isNum := node.right.(*NumNode); isNum && node.token.kind == Power && node.operandType == (TypeInt{}) && strings.HasPrefix(num.token.str, "-") {
+		tc.error(fmt.Sprintf("Int powers must have a non-negative exponent, got %s, use a float base instead", num.token.str))
+	}
+
+	node.typ = node.operandType
+	if node.token.kind == IntDiv {
+		node.typ = TypeInt{}
+	}
+	return node.typ
+}
+
+func (tc *TypeChecker) typecheckFieldAccess(node *FieldAccessNode) Type {
+	exprType := tc.typecheckExpr(node.expr)
+	recordType, isRecord := exprType.(TypeRecord)
+	if !isRecord {
+		tc.error(fmt.Sprintf("Cannot access field %q on non-record type %s", node.token.str, exprType))
+		node.typ = TypeUndetermined{}
+		return node.typ
+	}
+	recordSymbol, _ := tc.scope.lookupSymbol(recordType.Name)
+	for _, field := range recordSymbol.paramsNode.parameters {
+		if field.name == node.token.str {
+			node.typ = field.typ
+			return node.typ
+		}
+	}
+	tc.error(fmt.Sprintf("Record %q has no field %q", recordType.Name, node.token.str))
+	node.typ = TypeUndetermined{}
+	return node.typ
+}
+
+// Returns the type of the value indexed by node, eg. []int for grid[1][2] when grid is a [][]int
+func containerType(scope *Scope, node *IndexedVarNode) Type {
+	if node.container == nil {
+		symbol, _ := scope.lookupSymbol(node.token.str)
+		return symbol.typ
+	}
+	_, isRange := node.container.index.(*RangeNode)
+	switch t := containerType(scope, node.container).(type) {
+	case TypeSlice:
+		if isRange {
+			return t
+		}
+		return t.ElementType
+	case TypeMap:
+		return t.ValueType
+	case TypeString:
+		return TypeString{}
+	}
+	return TypeUndetermined{}
+}
+
+// Reports indexes that are implicitly converted to int, or to the key type of a map, in strict mode
+func (tc *TypeChecker) checkIndexConversion(node *IndexedVarNode) {
+	if _, isRange := node.index.(*RangeNode); isRange {
+		return
+	}
+	indexType := tc.typecheckExpr(node.index)
+	context := fmt.Sprintf("when used as index of %q", node.token.str)
+	switch t := containerType(tc.scope, node).(type) {
+	case TypeSlice, TypeString:
+		tc.checkImplicitConversion(indexType, TypeInt{}, node.token, context)
+	case TypeMap:
+		tc.checkImplicitConversion(indexType, t.KeyType, node.token, context)
+	}
+}
+
+// Checks a match statement or expression: that each pattern fits the subject, that no arm
+// follows `_`, and that the arms cover every value. Returns the type of a match expression.
+func (tc *TypeChecker) typecheckMatch(node *MatchNode) Type {
+	node.subjectType = tc.typecheckExpr(node.subject)
+	tc.traverse(node.subject)
+	if _, isUndetermined := node.subjectType.(TypeUndetermined); isUndetermined {
+		return TypeUndetermined{}
+	}
+
+	covered := make(map[string]bool)
+	hasWildcard := false
+	var armType Type
+	for i := range node.arms {
+		arm := &node.arms[i]
+		pattern := arm.pattern
+		if hasWildcard {
+			tc.error(fmt.Sprintf("Match arm %s is unreachable, it comes after _", pattern.token.str))
+		}
+
+		scope := arm.scope
+		if body, isCompound := arm.body.(*CompoundStatementNode); isCompound {
+			scope = body.scope
+		}
+
+		switch pattern.kind {
+		case WildcardPattern:
+			hasWildcard = true
+		case VariantPattern:
+			tc.typecheckVariantPattern(node.subjectType, pattern, scope, covered)
+		case LiteralPattern:
+			valueType := tc.typecheckExpr(pattern.value)
+			if valueType != node.subjectType && !(valueType == (TypeInt{}) && node.subjectType == (TypeFloat{})) {
+				tc.error(fmt.Sprintf("Cannot match %s against pattern %s of type %s", node.subjectType, pattern.token.str, valueType))
+			}
+			if valueType == (TypeBool{}) {
+				covered[pattern.value.(*BoolNode).token.str] = true
+			}
+		case RangePattern:
+			fromType := tc.typecheckExpr(pattern.value)
+			toType := tc.typecheckExpr(pattern.to)
+			if (node.subjectType != TypeInt{} && node.subjectType != TypeFloat{}) || fromType == (TypeFloat{}) && node.subjectType == (TypeInt{}) || toType == (TypeFloat{}) && node.subjectType == (TypeInt{}) {
+				tc.error(fmt.Sprintf("Cannot match %s against range pattern of type %s", node.subjectType, fromType))
+			}
+		case RegexPattern:
+			if node.subjectType != (TypeString{}) {
+				tc.error(fmt.Sprintf("Cannot match %s against a regex pattern, only str", node.subjectType))
+			}
+			if _, err := regexp.Compile(pattern.value.(*StringLiteralNode).token.str); err != nil {
+				tc.error(fmt.Sprintf("Invalid regex pattern: %s", err))
+			}
+		}
+
+		if !node.expression {
+			tc.traverse(arm.body)
+			continue
+		}
+		tc.scope = arm.scope
+		bodyType := tc.typecheckExpr(arm.body)
+		tc.traverse(arm.body)
+		tc.scope = tc.scope.parent
+		switch {
+		case armType == nil || armType == (TypeInt{}) && bodyType == (TypeFloat{}):
+			armType = bodyType
+		case bodyType == armType || bodyType == (TypeInt{}) && armType == (TypeFloat{}):
+		default:
+			tc.error(fmt.Sprintf("The arms of a match have different types: %s and %s", armType, bodyType))
+		}
+	}
+
+	if !hasWildcard {
+		tc.checkExhaustive(node.subjectType, covered)
+	}
+	if node.expression {
+		if armType == (TypeVoid{}) {
+			tc.error("The arms of a match used as a value must have values")
+		}
+		node.typ = armType
+		return armType
+	}
+	return TypeVoid{}
+}
+
+// The branches of an if expression are unified to a common type, like the elements of a list
+func (tc *TypeChecker) typecheckIfExpr(node *IfExprNode) Type {
+	node.compType = tc.typecheckExpr(node.comp)
+	tc.checkCondition(node.comp, node.compType, node.token)
+	tc.traverse(node.comp)
+
+	bodyType := tc.typecheckBranch(node.body, node.bodyScope)
+	elseType := tc.typecheckBranch(node.elseBody, node.elseScope)
+	if bodyType == (TypeVoid{}) || elseType == (TypeVoid{}) {
+		tc.error("The branches of an if used as a value must have values")
+		return TypeUndetermined{}
+	}
+	common, ok := commonType(bodyType, elseType)
+	if !ok {
+		tc.error(fmt.Sprintf("The branches of an if expression have different types: %s and %s", bodyType, elseType))
+		common = TypeUndetermined{}
+	}
+	tc.checkImplicitConversion(bodyType, common, node.token, "in if expression")
+	tc.checkImplicitConversion(elseType, common, node.token, "in if expression")
+
+	// An `else if` assigns to the result of the outermost if, so its values must have the same type
+	for elseIf := node; elseIf != nil; elseIf, _ = elseIf.elseBody.(*IfExprNode) {
+		elseIf.typ = common
+	}
+	return common
+}
+
+// Checks an expression evaluated in a scope of its own, if it has one
+func (tc *TypeChecker) typecheckBranch(node Node, scope *Scope) Type {
+	if scope == nil {
+		return tc.typecheckExpr(node)
+	}
+	tc.scope = scope
+	typ := tc.typecheckExpr(node)
+	tc.traverse(node)
+	tc.scope = tc.scope.parent
+	return typ
+}
+
+// Checks a pattern such as `Shape.Circle(r)` and sets the types of the names the payload is bound to
+func (tc *TypeChecker) typecheckVariantPattern(subjectType Type, pattern MatchPattern, scope *Scope, covered map[string]bool) {
+	name := pattern.enum + "." + pattern.variant
+	if subjectType != (TypeEnum{Name: pattern.enum}) {
+		tc.error(fmt.Sprintf("Cannot match %s against pattern %s", subjectType, name))
+		return
+	}
+	symbol, found := tc.scope.lookupSymbol(name)
+	if !found || symbol.category != VariantSymbol {
+		tc.error(fmt.Sprintf("Enum %s has no variant %q", pattern.enum, pattern.variant))
+		return
+	}
+	if covered[pattern.variant] {
+		tc.error(fmt.Sprintf("Variant %s is matched more than once", name))
+	}
+	covered[pattern.variant] = true
+
+	// Without parentheses the payload is ignored, eg. `Shape.Circle => ...`
+	fields := symbol.paramsNode.parameters
+	if len(pattern.bindings) == 0 {
+		return
+	}
+	if len(pattern.bindings) != len(fields) {
+		tc.error(fmt.Sprintf("Pattern %s has %d bindings, but the variant has %d fields", name, len(pattern.bindings), len(fields)))
+		return
+	}
+	for i, binding := range pattern.bindings {
+		if binding != "_" {
+			scope.setSymbolType(binding, fields[i].typ)
+		}
+	}
+}
+
+// Matches without `_` must cover every variant of an enum, or both values of a bool
+func (tc *TypeChecker) checkExhaustive(subjectType Type, covered map[string]bool) {
+	var missing []string
+	switch t := subjectType.(type) {
+	case TypeEnum:
+		symbol, _ := tc.scope.lookupSymbol(t.Name)
+		for _, variant := range symbol.variants {
+			if !covered[variant] {
+				missing = append(missing, t.Name+"."+variant)
+			}
+		}
+	case TypeBool:
+		for _, value := range []string{"true", "false"} {
+			if !covered[value] {
+				missing = append(missing, value)
+			}
+		}
+	default:
+		tc.error(fmt.Sprintf("Match on %s is not exhaustive, add a _ arm", subjectType))
+		return
+	}
+	if len(missing) > 0 {
+		tc.error(fmt.Sprintf("Match on %s is not exhaustive, missing %s", subjectType, strings.Join(missing, ", ")))
+	}
+}
+
+// Checks that any records referred to by a declared type exist
+func (tc *TypeChecker) validateType(typ Type) {
+	switch t := typ.(type) {
+	case TypeRecord:
+		symbol, found := tc.scope.lookupSymbol(t.Name)
+		if !found || symbol.category != RecordSymbol {
+			tc.error(fmt.Sprintf("Unknown type %q", t.Name))
+		}
+	case TypeSlice:
+		tc.validateType(t.ElementType)
+	case TypeSet:
+		tc.validateType(t.ElementType)
+	case TypeMap:
+		tc.validateType(t.KeyType)
+		tc.validateType(t.ValueType)
+	case TypeGenerator:
+		tc.validateType(t.ElementType)
+	case TypeTuple:
+		for _, typ := range t.ElementTypes() {
+			tc.validateType(typ)
+		}
+	}
+}
+
+// Sets the types of the variables a tuple is destructured into in a loop body, eg. `for pairs -> (a, b)`
+func (tc *TypeChecker) setDestructuredTypes(body Node, targets []VarNode, typ Type) {
+	tupleType, isTuple := typ.(TypeTuple)
+	if !isTuple || tupleType.Size != len(targets) {
+		tc.error(fmt.Sprintf("Cannot destructure %s into %d variables", typ, len(targets)))
+		return
+	}
+	for i, target := range targets {
+		if target.token.str != "_" {
+			body.(*CompoundStatementNode).SetVarType(target.token.str, tupleType.Elements[i])
+		}
+	}
+}
+
+func (tc *TypeChecker) typecheckMapLiteral(node *MapLiteralNode) Type {
+	if node.keyType == (TypeUndetermined{}) {
+		node.keyType = tc.typecheckExprList(node.keys)
+		node.valueType = tc.typecheckExprList(node.values)
+		if !isMapKey(node.keyType) {
+			tc.error(fmt.Sprintf("Map keys must be int, float or str, not %s", node.keyType))
+		}
+		constrainTypeParam(tc.typeParamConstraints, node.keyType, OrderedConstraint)
+	} else {
+		for i := range node.keys {
+			tc.typecheckExpr(node.keys[i])
+			tc.typecheckExpr(node.values[i])
+		}
+	}
+
+	// Duplicate literal keys would otherwise only be caught by the go compiler
+	seen := make(map[string]bool)
+	for _, key := range node.keys {
+		var keyStr string
+		switch k := key.(type) {
+		case *StringLiteralNode:
+			keyStr = strconv.Quote(k.token.str)
+		case *NumNode:
+			keyStr = k.token.str
+		default:
+			continue
+		}
+		if seen[keyStr] {
+			tc.error(fmt.Sprintf("Duplicate key %s in map literal", keyStr))
+		}
+		seen[keyStr] = true
+	}
+
+	return TypeMap{KeyType: node.keyType, ValueType: node.valueType}
+}
+
 func (tc *TypeChecker) traverse(node Node) {
 
 	switch n := node.(type) {
 
 	case *ProgramNode:
+		tc.scope = n.scope
+		tc.strictFiles = n.strictFiles
+		tc.fileNames = n.fileNames
+		for _, record := range n.records {
+			tc.traverse(record)
+		}
+		for _, enum := range n.enums {
+			tc.traverse(enum)
+		}
+		for _, global := range n.globals {
+			tc.traverse(global)
+		}
 		for _, function := range n.functions {
 			tc.traverse(function)
 		}
 
+	case *ConstNode:
+		return
+
+	case *RecordNode:
+		for _, field := range n.fields.(*ParameterListNode).parameters {
+			tc.validateType(field.typ)
+		}
+
+	case *EnumNode:
+		for _, variant := range n.variants {
+			for _, field := range variant.fields.parameters {
+				tc.validateType(field.typ)
+			}
+		}
+
 	case *FunctionNode:
+		for _, param := range n.parameters.(*ParameterListNode).parameters {
+			tc.validateType(param.typ)
+		}
+		tc.validateType(n.returnType)
+		if len(n.typeParams) > 0 {
+			tc.typeParamConstraints = n.typeParamConstraints
+			defer func() { tc.typeParamConstraints = nil }()
+		}
 		tc.traverse(n.body)
 
 	case *CompoundStatementNode:
@@ -260,28 +1229,134 @@ func (tc *TypeChecker) traverse(node Node) {
 		}
 		tc.scope = tc.scope.parent
 
+	case *WhileNode:
+		if _, isInfinite := n.condition.(*NoOpNode); !isInfinite {
+			n.conditionType = tc.typecheckExpr(n.condition)
+			tc.checkCondition(n.condition, n.conditionType, n.token)
+			tc.traverse(n.condition)
+		}
+		tc.traverse(n.body)
+
 	case *IfNode:
 		compType := tc.typecheckExpr(n.comp)
 		node.(*IfNode).setCompType(compType)
+		tc.checkCondition(n.comp, compType, n.token)
 		// TODO: Ensure that comparison is a boolean value
 		tc.traverse(n.comp)
 		tc.traverse(n.body)
 		tc.traverse(n.elseBody)
 
 	case *AssignNode:
-		if !n.expression {
+		if field, isField := n.left.(*FieldAccessNode); isField {
+			fieldType := tc.typecheckExpr(field)
+			tc.traverse(field.expr)
+			tc.checkImplicitConversion(tc.typecheckExpr(n.right), fieldType, n.token, fmt.Sprintf("when assigned to field %q", field.token.str))
+			tc.traverse(n.right)
+		} else if indexed, isIndexed := n.left.(*IndexedVarNode); isIndexed {
+			lhsType := containerType(tc.scope, indexed)
+			rhsType := tc.typecheckExpr(n.right)
+			switch t := lhsType.(type) {
+			case TypeMap:
+				tc.typecheckExpr(indexed)
+				if !isCoercible(rhsType, t.ValueType) {
+					tc.error(fmt.Sprintf("Cannot assign %s to element of %q of type %s", rhsType, indexed.token.str, lhsType))
+				}
+				tc.checkImplicitConversion(rhsType, t.ValueType, n.token, fmt.Sprintf("when assigned to element of %q", indexed.token.str))
+			case TypeSlice:
+				// Range targets are replaced by the elements of a slice, eg. `a[1..3] = [7, 8, 9]`
+				if _, isRange := indexed.index.(*RangeNode); isRange {
+					if _, isSlice := rhsType.(TypeSlice); !isSlice {
+						tc.error(fmt.Sprintf("Cannot assign %s to range of slice %q, expected a slice", rhsType, indexed.token.str))
+					} else if !isCoercible(rhsType, t) {
+						tc.error(fmt.Sprintf("Cannot assign %s to range of %q of type %s", rhsType, indexed.token.str, lhsType))
+					}
+					tc.checkImplicitConversion(rhsType, t, n.token, fmt.Sprintf("when assigned to range of %q", indexed.token.str))
+				} else {
+					if !isCoercible(rhsType, t.ElementType) {
+						tc.error(fmt.Sprintf("Cannot assign %s to element of %q of type %s", rhsType, indexed.token.str, lhsType))
+					}
+					tc.checkImplicitConversion(rhsType, t.ElementType, n.token, fmt.Sprintf("when assigned to element of %q", indexed.token.str))
+				}
+			case TypeString:
+				tc.error(fmt.Sprintf("Cannot assign to element of string %q, strings are immutable", indexed.token.str))
+			case TypeUndetermined:
+				// The container is not indexable, reported when it is checked
+			default:
+				tc.error(fmt.Sprintf("Cannot assign to element of %q of type %s", indexed.token.str, lhsType))
+			}
+			tc.checkIndexConversion(indexed)
+			tc.traverse(indexed.index)
+			if indexed.container != nil {
+				tc.traverse(indexed.container)
+			}
+			tc.traverse(n.right)
+		} else if !n.expression {
 			lhsSymbol, found := tc.scope.lookupSymbol(n.left.(*VarNode).token.str)
 			if found && lhsSymbol.typ.String() == "Undetermined" {
 				rhsType := tc.typecheckExpr(n.right)
+				tc.checkHasValue(n.right, fmt.Sprintf("when assigned to %q", n.left.(*VarNode).token.str))
 				tc.scope.setSymbolType(n.left.(*VarNode).token.str, rhsType)
 			} else {
-				// Do nothing?
+				if found {
+					tc.inferLambda(n.right, lhsSymbol.typ)
+				}
+				// Annotate the rhs, eg. the element type of slice literals
+				rhsType := tc.typecheckExpr(n.right)
+				tc.checkHasValue(n.right, fmt.Sprintf("when assigned to %q", n.left.(*VarNode).token.str))
+				if found {
+					tc.checkExactType(rhsType, lhsSymbol.typ, fmt.Sprintf("when assigned to %q", n.left.(*VarNode).token.str))
+					tc.checkImplicitConversion(rhsType, lhsSymbol.typ, n.token, fmt.Sprintf("when assigned to %q", n.left.(*VarNode).token.str))
+				}
 			}
 			tc.traverse(n.right)
 		}
 
 	case *ReturnNode:
+		_, inGenerator := tc.scope.closestReturningScope().returnType.(TypeGenerator)
+		_, isBare := n.expr.(*NoOpNode)
+		if inGenerator && !isBare {
+			tc.error("Cannot return a value from a generator function, use `yield`")
+		}
+		returnType := tc.scope.closestReturningScope().returnType
+		tc.inferLambda(n.expr, returnType)
 		n.setType(tc.typecheckExpr(n.expr))
+		if !inGenerator && !isBare {
+			tc.checkExactType(n.typ, returnType, "when returned")
+			tc.checkImplicitConversion(n.typ, returnType, n.token, "when returned")
+		}
+		tc.traverse(n.expr)
+
+	case *DestructureNode:
+		rhsType := tc.typecheckExpr(n.right)
+		tupleType, isTuple := rhsType.(TypeTuple)
+		if !isTuple || tupleType.Size != len(n.targets) {
+			tc.error(fmt.Sprintf("Cannot destructure %s into %d variables", rhsType, len(n.targets)))
+		} else {
+			n.tupleType = tupleType
+			for i, target := range n.targets {
+				if n.declarations[i] {
+					tc.scope.setSymbolType(target.token.str, tupleType.Elements[i])
+				}
+			}
+		}
+		tc.traverse(n.right)
+
+	case *TupleLiteralNode:
+		tc.typecheckExpr(n)
+		for _, element := range n.elements {
+			tc.traverse(element)
+		}
+
+	case *YieldNode:
+		generatorType, inGenerator := tc.scope.closestReturningScope().returnType.(TypeGenerator)
+		if !inGenerator {
+			tc.error("Cannot use `yield` outside of a generator function")
+		}
+		n.setType(tc.typecheckExpr(n.expr))
+		if inGenerator {
+			tc.checkImplicitConversion(n.typ, generatorType.ElementType, n.token, "when yielded")
+		}
+		tc.traverse(n.expr)
 
 	case *FailNode:
 		if !tc.scope.closestReturningScope().fallible {
@@ -299,16 +1374,27 @@ func (tc *TypeChecker) traverse(node Node) {
 
 		var parameters []ParameterNode
 
-		if isBuiltin(functionName) {
+		if fnNode.isBuiltin {
 			_ = tc.typecheckBuiltin(node)
 			parameters = builtins[functionName].parameters
+			if builtins[functionName].fallible && !fnNode.errorHandled {
+				tc.error(fmt.Sprintf("Function %q can return an error, but it is not handled", functionName))
+			}
+			if !builtins[functionName].fallible && fnNode.errorHandled {
+				tc.error(fmt.Sprintf("Function %q is not fallible, do not put ? after the call to it", functionName))
+			}
 		} else {
 			symbol, found := tc.scope.lookupSymbol(functionName)
 			if found {
-				if symbol.category != FunctionSymbol {
+				var isCallable bool
+				parameters, _, isCallable = symbol.signature()
+				if _, isUndetermined := symbol.typ.(TypeUndetermined); isUndetermined {
+					return
+				}
+				if !isCallable {
 					tc.error(fmt.Sprintf("%q is not a function", functionName))
+					return
 				}
-				parameters = symbol.paramsNode.parameters
 
 				// Check that errors are handled correctly
 				if symbol.fallible && !fnNode.errorHandled {
@@ -318,10 +1404,23 @@ func (tc *TypeChecker) traverse(node Node) {
 					tc.error(fmt.Sprintf("Function %q is not fallible, do not put ? after the call to it", functionName))
 				}
 
+				// Generators can only be consumed with `->`, and only generators can
+				_, isGenerator := symbol.typ.(TypeGenerator)
+				if isGenerator && fnNode.generatorBody == nil && !fnNode.streamed {
+					tc.error(fmt.Sprintf("Generator function %q must be consumed with \"->\"", functionName))
+				}
+				if !isGenerator && fnNode.generatorBody != nil {
+					tc.error(fmt.Sprintf("Cannot put \"->\" after non-generator function %q", functionName))
+					return
+				}
+
 			} else if functionName == "print" {
 				// TODO: Make print a builtin
 				for _, arg := range fnNode.arguments {
-					tc.traverse(arg.(*ArgumentNode).expr)
+					if _, isFunction := tc.typecheckExpr(arg.(*ArgumentNode).expr).(TypeFunction); isFunction {
+						tc.error("Cannot print function values")
+					}
+					tc.traverse(arg)
 				}
 				return
 			} else {
@@ -333,6 +1432,15 @@ func (tc *TypeChecker) traverse(node Node) {
 		err := fnNode.matchArgsToParams(parameters)
 		if err != nil {
 			tc.error(err.Error())
+		} else if !fnNode.isBuiltin {
+			symbol, _ := tc.scope.lookupSymbol(functionName)
+			parameters, _ = tc.instantiateGeneric(fnNode, symbol, parameters, symbol.typ)
+			tc.inferArgumentLambdas(fnNode, parameters)
+			for _, param := range parameters {
+				argType := tc.typecheckExpr(fnNode.resolvedArgs[param.name].expr)
+				tc.checkExactType(argType, param.typ, fmt.Sprintf("for argument %q of %q", param.name, functionName))
+				tc.checkImplicitConversion(argType, param.typ, fnNode.token, fmt.Sprintf("for argument %q of %q", param.name, functionName))
+			}
 		}
 
 		if fnNode.errorBody != nil {
@@ -344,9 +1452,20 @@ func (tc *TypeChecker) traverse(node Node) {
 		}
 
 		if fnNode.generatorVar != (VarNode{}) {
-			//controlVarType := builtins[functionName].returnType.(TypeGenerator).GetElementType()
-			controlVarType := tc.typecheckBuiltin(node)
+			var controlVarType Type
+			if fnNode.isBuiltin {
+				controlVarType = tc.typecheckBuiltin(node)
+			} else {
+				symbol, _ := tc.scope.lookupSymbol(functionName)
+				controlVarType = symbol.typ.(TypeGenerator).ElementType
+				if len(symbol.typeParams) > 0 {
+					controlVarType = substituteTypeParams(controlVarType, fnNode.typeArgs)
+				}
+			}
 			fnNode.generatorBody.(*CompoundStatementNode).SetVarType(fnNode.generatorVar.token.str, controlVarType)
+			if len(fnNode.generatorDestructure) > 0 {
+				tc.setDestructuredTypes(fnNode.generatorBody, fnNode.generatorDestructure, controlVarType)
+			}
 		}
 		if fnNode.generatorBody != nil {
 			tc.traverse(fnNode.generatorBody)
@@ -354,42 +1473,131 @@ func (tc *TypeChecker) traverse(node Node) {
 
 
 	case *IndexedVarNode:
+		if rangeNode, isRange := n.index.(*RangeNode); isRange {
+			if _, noStep := rangeNode.step.(*NoOpNode); !noStep {
+				tc.error(fmt.Sprintf("Cannot index %q with a stepped range", n.token.str))
+			}
+		}
+		tc.checkIndexConversion(n)
 		tc.traverse(n.index)
+		if n.container != nil {
+			tc.traverse(n.container)
+		}
 
 	case *RangeNode:
+		tc.typecheckExpr(n)
 		tc.traverse(n.from)
 		tc.traverse(n.to)
+		tc.traverse(n.step)
 
 	case *ArgumentNode:
+		tc.checkHasValue(n.expr, "as an argument")
+		tc.traverse(n.expr)
+
+	case *FieldAccessNode:
+		tc.typecheckExpr(n)
 		tc.traverse(n.expr)
 
 	case *BinOpNode:
+		tc.checkHasValue(n.left, fmt.Sprintf("as an operand of %s", n.token.str))
+		tc.checkHasValue(n.right, fmt.Sprintf("as an operand of %s", n.token.str))
+		if n.isArithmetic() {
+			tc.typecheckExpr(n)
+		} else {
+			tc.checkOperands(n)
+		}
 		tc.traverse(n.left)
 		tc.traverse(n.right)
 
 	case *SliceLiteralNode:
+		tc.typecheckExpr(n)
 		for _, el := range n.elements {
+			tc.checkImplicitConversion(tc.typecheckExpr(el), n.elementType, n.token, "in slice literal")
 			tc.traverse(el)
 		}
 
 	case *SetLiteralNode:
+		tc.typecheckExpr(n)
 		for _, el := range n.elements {
+			tc.checkImplicitConversion(tc.typecheckExpr(el), n.elementType, n.token, "in set literal")
 			tc.traverse(el)
 		}
 
+	case *MapLiteralNode:
+		tc.typecheckMapLiteral(n)
+		for i := range n.keys {
+			tc.checkImplicitConversion(tc.typecheckExpr(n.keys[i]), n.keyType, n.token, "in map literal")
+			tc.checkImplicitConversion(tc.typecheckExpr(n.values[i]), n.valueType, n.token, "in map literal")
+			tc.traverse(n.keys[i])
+			tc.traverse(n.values[i])
+		}
+
 	case *ForeachNode:
 		var controlVarType Type
 
 		switch n.iterator.(type) {
 		case *RangeNode:
+			tc.traverse(n.iterator)
 			controlVarType = TypeInt{}
 		default:
 			iterType := tc.typecheckExpr(n.iterator)
 			controlVarType = iterType.(IterableType).GetElementType()
+			n.iterType = iterType
+
+			// For maps, the second control variable is the value rather than an index
+			if mapType, isMap := iterType.(TypeMap); isMap && n.hasIdx {
+				n.body.(*CompoundStatementNode).SetVarType(n.idxVariable.token.str, mapType.ValueType)
+			}
 		}
 		n.body.(*CompoundStatementNode).SetVarType(n.variable.token.str, controlVarType)
+		if len(n.destructure) > 0 {
+			tc.setDestructuredTypes(n.body, n.destructure, controlVarType)
+		}
 		tc.traverse(n.body)
 
+	case *CompoundAssignNode:
+		n.targetType = tc.typecheckExpr(n.left)
+		n.valueType = tc.typecheckExpr(n.right)
+		isNumber := func(typ Type) bool { return typ == (TypeInt{}) || typ == (TypeFloat{}) }
+
+		if indexed, isIndexed := n.left.(*IndexedVarNode); isIndexed && containerType(tc.scope, indexed) == (TypeString{}) {
+			tc.error(fmt.Sprintf("Cannot assign to element of string %q, strings are immutable", indexed.token.str))
+			tc.traverse(n.right)
+			return
+		}
+
+		switch targetType := n.targetType.(type) {
+		case TypeInt, TypeFloat:
+			if !isNumber(n.valueType) {
+				tc.error(fmt.Sprintf("Cannot use %s to combine %s with %s", n.token.str, n.targetType, n.valueType))
+			} else {
+				tc.checkImplicitConversion(n.valueType, targetType, n.token, fmt.Sprintf("by %s", n.token.str))
+			}
+		case TypeString:
+			if n.token.kind != PlusAssign {
+				tc.error(fmt.Sprintf("Cannot use %s on type %s", n.token.str, n.targetType))
+			} else if !isNumber(n.valueType) && n.valueType != (TypeString{}) && n.valueType != (TypeBool{}) {
+				tc.error(fmt.Sprintf("Cannot append %s to str", n.valueType))
+			} else {
+				tc.checkImplicitConversion(n.valueType, targetType, n.token, fmt.Sprintf("by %s", n.token.str))
+			}
+		case TypeSlice:
+			if n.token.kind != PlusAssign {
+				tc.error(fmt.Sprintf("Cannot use %s on type %s", n.token.str, n.targetType))
+			} else if n.valueType == targetType.ElementType || isNumber(n.valueType) && isNumber(targetType.ElementType) {
+				tc.checkImplicitConversion(n.valueType, targetType.ElementType, n.token, fmt.Sprintf("by %s", n.token.str))
+			} else if isCoercible(n.valueType, targetType) {
+				// The elements of a slice are appended, eg. `floats += [2, 3]`
+				tc.checkImplicitConversion(n.valueType, targetType, n.token, fmt.Sprintf("by %s", n.token.str))
+			} else {
+				tc.error(fmt.Sprintf("Cannot append %s to %s", n.valueType, n.targetType))
+			}
+		default:
+			tc.error(fmt.Sprintf("Cannot use %s on type %s", n.token.str, n.targetType))
+		}
+		tc.traverse(n.left)
+		tc.traverse(n.right)
+
 	case *IncNode:
 		varSymbol, _ := tc.scope.lookupSymbol(n.varName)
 		switch varSymbol.typ.(type) {
@@ -405,9 +1613,37 @@ func (tc *TypeChecker) traverse(node Node) {
 			tc.error(fmt.Sprintf("Cannot use -- operator on non-numeric types"))
 		}
 
-	case *StringLiteralNode, *NumNode, *BoolNode, *VarNode, *NoOpNode, *UnaryOpNode, *ContinueNode, *BreakNode:
+	case *StringLiteralNode, *NumNode, *BoolNode, *NoOpNode, *ContinueNode, *BreakNode:
 		return
 
+	case *VarNode:
+		// Named functions used as values
+		if symbol, found := tc.scope.lookupSymbol(n.token.str); found && symbol.category == FunctionSymbol {
+			tc.typecheckFunctionValue(symbol)
+		}
+
+	case *LambdaNode:
+		tc.typecheckExpr(n)
+		tc.scope = n.scope
+		tc.traverse(n.body)
+		tc.scope = tc.scope.parent
+
+	case *UnaryOpNode:
+		tc.typecheckExpr(n)
+		tc.traverse(n.expr)
+
+	case *MatchNode:
+		tc.typecheckMatch(n)
+
+	case *IfExprNode:
+		tc.typecheckIfExpr(n)
+
+	case *InterpolatedStringNode:
+		tc.typecheckExpr(n)
+		for _, part := range n.parts {
+			tc.traverse(part)
+		}
+
 	default:
 		fmt.Printf("TYPECHECKING TODO: %T\n", node)
 		os.Exit(1)
@@ -415,17 +1651,18 @@ func (tc *TypeChecker) traverse(node Node) {
 	}
 }
 
-func CheckTypes(root Node) (Node, error) {
-	typeChecker := TypeChecker{nil, []string{}, make(map[string]bool)}
+func CheckTypes(root Node, strict bool) (Node, error) {
+	typeChecker := TypeChecker{nil, []string{}, make(map[string]bool), strict, nil, nil, nil, nil}
 
 	typeChecker.traverse(root)
+	typeChecker.checkTypeArgs()
 
 	for importName, _ := range typeChecker.imports {
 		root.(*ProgramNode).addImport(importName)
 	}
 
 	if len(typeChecker.errors) > 0 {
-		return root, fmt.Errorf(strings.Join(typeChecker.errors, "\n"))
+		return root, errors.New(strings.Join(typeChecker.errors, "\n"))
 	}
 
 	return root, nil
@@ -1,5 +1,7 @@
 package parser
 
+import "strings"
+
 type Type interface {
 	String() string
 }
@@ -57,6 +59,86 @@ func (t TypeSet) String() string       { return "set(" + t.ElementType.String()
 func (t TypeSet) GetElementType() Type { return t.ElementType }
 
 
+type TypeMap struct {
+	KeyType   Type
+	ValueType Type
+}
+
+func (t TypeMap) String() string       { return "map[" + t.KeyType.String() + "]" + t.ValueType.String() }
+func (t TypeMap) GetElementType() Type { return t.KeyType }
+
+// User-defined record, the fields are looked up from the record's symbol
+type TypeRecord struct {
+	Name string
+}
+
+func (t TypeRecord) String() string { return t.Name }
+
+const maxTupleSize = 8
+
+// Tuples keep their element types in an array rather than a slice, so that tuple
+// types are comparable like all other types
+type TypeTuple struct {
+	Elements [maxTupleSize]Type
+	Size     int
+}
+
+func newTupleType(types []Type) TypeTuple {
+	tuple := TypeTuple{Size: len(types)}
+	copy(tuple.Elements[:], types)
+	return tuple
+}
+
+func (t TypeTuple) ElementTypes() []Type { return t.Elements[:t.Size] }
+func (t TypeTuple) String() string {
+	var elements []string
+	for _, typ := range t.ElementTypes() {
+		elements = append(elements, typ.String())
+	}
+	return "(" + strings.Join(elements, ", ") + ")"
+}
+
+// Functions as values, eg. `fn(str, int) -> int`. Like tuples, the parameter types
+// are kept in an array so that function types are comparable.
+type TypeFunction struct {
+	Params     [maxTupleSize]Type
+	ParamCount int
+	ReturnType Type
+}
+
+func newFunctionType(params []Type, returnType Type) TypeFunction {
+	function := TypeFunction{ParamCount: len(params), ReturnType: returnType}
+	copy(function.Params[:], params)
+	return function
+}
+
+func (t TypeFunction) ParamTypes() []Type { return t.Params[:t.ParamCount] }
+func (t TypeFunction) String() string {
+	var params []string
+	for _, typ := range t.ParamTypes() {
+		params = append(params, typ.String())
+	}
+	str := "fn(" + strings.Join(params, ", ") + ")"
+	if t.ReturnType != (TypeVoid{}) {
+		str += " -> " + t.ReturnType.String()
+	}
+	return str
+}
+
+// User defined enums, eg. `Shape` for `enum Shape { Circle(r float) Empty }`
+type TypeEnum struct {
+	Name string
+}
+
+func (t TypeEnum) String() string { return t.Name }
+
+// Type parameters of generic functions, eg. `T` in `fn first<T>(xs []T) -> T`
+type TypeParam struct {
+	Name string
+}
+
+func (t TypeParam) String() string { return t.Name }
+
 type TypeGenerator struct {
 	ElementType Type
 }
@@ -82,9 +164,151 @@ func isSettable(t Type) bool {
 	}
 }
 
+// Sets and maps, anything that can be looked up by key
+func isKeyed(t Type) bool {
+	switch t.(type) {
+	case TypeSet, TypeMap:
+		return true
+	default:
+		return false
+	}
+}
+
+// Map keys are restricted to ordered types so that iteration order can be defined. Type
+// parameters used as keys are constrained to ordered types.
+func isMapKey(t Type) bool {
+	switch t.(type) {
+	case TypeInt, TypeFloat, TypeString, TypeParam:
+		return true
+	default:
+		return false
+	}
+}
+
+// Constraints on the types a type parameter can be instantiated with, from how the
+// parameter is used in its function
+type TypeConstraint int
+
+const (
+	AnyConstraint        TypeConstraint = iota
+	ComparableConstraint                // Compared with == or !=
+	OrderedConstraint                   // Used as a map key or set element
+)
+
+func (c TypeConstraint) satisfiedBy(t Type) bool {
+	switch c {
+	case ComparableConstraint:
+		return isMapKey(t) || t == TypeBool{}
+	case OrderedConstraint:
+		return isMapKey(t)
+	default:
+		return true
+	}
+}
+
+func (c TypeConstraint) String() string {
+	switch c {
+	case ComparableConstraint:
+		return "int, float, str or bool, it is compared with == or !="
+	case OrderedConstraint:
+		return "int, float or str, it is used as a map key or set element"
+	default:
+		return "any type"
+	}
+}
+
+// Constrains typ if it is a type parameter. Type parameters keep the strictest constraint
+// they are used with.
+func constrainTypeParam(constraints map[string]TypeConstraint, typ Type, constraint TypeConstraint) {
+	if param, isParam := typ.(TypeParam); isParam && constraints[param.Name] < constraint {
+		constraints[param.Name] = constraint
+	}
+}
+
+// Binds the type parameters in a parameter type to the matching parts of the argument type,
+// eg. `T` to `int` for `[]T` and `[]int`. A type parameter keeps the first type bound to it.
+func bindTypeParams(paramType Type, argType Type, bindings map[string]Type) {
+	if _, isUndetermined := argType.(TypeUndetermined); isUndetermined {
+		return
+	}
+	switch t := paramType.(type) {
+	case TypeParam:
+		if _, found := bindings[t.Name]; !found {
+			bindings[t.Name] = argType
+		}
+	case TypeSlice:
+		if arg, isSlice := argType.(TypeSlice); isSlice {
+			bindTypeParams(t.ElementType, arg.ElementType, bindings)
+		}
+	case TypeSet:
+		if arg, isSet := argType.(TypeSet); isSet {
+			bindTypeParams(t.ElementType, arg.ElementType, bindings)
+		}
+	case TypeGenerator:
+		if arg, isGenerator := argType.(TypeGenerator); isGenerator {
+			bindTypeParams(t.ElementType, arg.ElementType, bindings)
+		}
+	case TypeMap:
+		if arg, isMap := argType.(TypeMap); isMap {
+			bindTypeParams(t.KeyType, arg.KeyType, bindings)
+			bindTypeParams(t.ValueType, arg.ValueType, bindings)
+		}
+	case TypeTuple:
+		if arg, isTuple := argType.(TypeTuple); isTuple && arg.Size == t.Size {
+			for i, typ := range t.ElementTypes() {
+				bindTypeParams(typ, arg.Elements[i], bindings)
+			}
+		}
+	case TypeFunction:
+		if arg, isFunction := argType.(TypeFunction); isFunction && arg.ParamCount == t.ParamCount {
+			for i, typ := range t.ParamTypes() {
+				bindTypeParams(typ, arg.Params[i], bindings)
+			}
+			bindTypeParams(t.ReturnType, arg.ReturnType, bindings)
+		}
+	}
+}
+
+// Replaces type parameters by the types bound to them. Unbound type parameters become undetermined.
+func substituteTypeParams(typ Type, bindings map[string]Type) Type {
+	switch t := typ.(type) {
+	case TypeParam:
+		if bound, found := bindings[t.Name]; found {
+			return bound
+		}
+		return TypeUndetermined{}
+	case TypeSlice:
+		return TypeSlice{ElementType: substituteTypeParams(t.ElementType, bindings)}
+	case TypeSet:
+		return TypeSet{ElementType: substituteTypeParams(t.ElementType, bindings)}
+	case TypeGenerator:
+		return TypeGenerator{ElementType: substituteTypeParams(t.ElementType, bindings)}
+	case TypeMap:
+		return TypeMap{KeyType: substituteTypeParams(t.KeyType, bindings), ValueType: substituteTypeParams(t.ValueType, bindings)}
+	case TypeTuple:
+		var elements []Type
+		for _, element := range t.ElementTypes() {
+			elements = append(elements, substituteTypeParams(element, bindings))
+		}
+		return newTupleType(elements)
+	case TypeFunction:
+		var params []Type
+		for _, param := range t.ParamTypes() {
+			params = append(params, substituteTypeParams(param, bindings))
+		}
+		return newFunctionType(params, substituteTypeParams(t.ReturnType, bindings))
+	}
+	return typ
+}
+
+// Whether a type is or contains a type parameter, eg. `[]T`
+func hasTypeParams(typ Type) bool {
+	return substituteTypeParams(typ, nil) != typ
+}
+
 func isGeneric(t Type) bool {
 	switch t.(type) {
-	case TypeInt, TypeFloat, TypeString, TypeBool, TypeUndetermined, TypeVoid, NoCoercion, NoReturn, TypeSlice, TypeGenerator, TypeSet:
+	case TypeInt, TypeFloat, TypeString, TypeBool, TypeUndetermined, TypeVoid, NoCoercion, NoReturn, TypeSlice, TypeGenerator, TypeSet, TypeMap, TypeRecord, TypeTuple, TypeFunction, TypeParam, TypeEnum:
 		return false
 	default:
 		return true
@@ -100,8 +324,6 @@ type TypeAppendable struct{}
 
 func (t TypeAppendable) String() string { return "appendable" }
 
-//type MapType struct {
-//	KeyType   Type
-//	ValueType Type
-//}
-//func (t MapType) String() string { return "map[" + t.KeyType.String() + "]" + t.ValueType.String() }
+type TypeKeyed struct{}
+
+func (t TypeKeyed) String() string { return "keyed" }